@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"regexp"
 
 	"github.com/BurntSushi/toml"
@@ -12,8 +13,28 @@ type CategoryConfig struct {
 	Index           string `toml:"index"`
 	NoteFilePattern string `toml:"note_file_pattern"`
 	NoteFileRegExp  *regexp.Regexp
+
+	CategoryTemplate string `toml:"category_template"`
+	ContentTemplate  string `toml:"content_template"`
+
+	ExcludeFromArchive bool `toml:"exclude_from_archive"` // applies to this and sub categories
+
+	Sort      string   `toml:"sort"`       // optional, one of SortByName, SortByDate, SortByWeight, SortByMtime, SortManually
+	SortOrder string   `toml:"sort_order"` // optional, "asc" or "desc"
+	Order     []string `toml:"order"`      // optional, file names of items to be placed first
 }
 
+const (
+	SortByName   = "name"
+	SortByDate   = "date"
+	SortByWeight = "weight"
+	SortByMtime  = "mtime"
+	SortManually = "manual"
+
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
 func GetCategoryConfig(dirPath string) (*CategoryConfig, error) {
 	configPath := dirPath + "/" + GetSiteConfig().Note.CategoryConfigFile
 	conf := new(CategoryConfig)
@@ -27,5 +48,19 @@ func GetCategoryConfig(dirPath string) (*CategoryConfig, error) {
 		}
 		conf.NoteFileRegExp = regex
 	}
+	switch conf.Sort {
+	case "":
+		conf.Sort = SortManually
+	case SortByName, SortByDate, SortByWeight, SortByMtime, SortManually:
+	default:
+		return nil, fmt.Errorf("sort MUST be one of name, date, weight, mtime and manual")
+	}
+	switch conf.SortOrder {
+	case "":
+		conf.SortOrder = SortAscending
+	case SortAscending, SortDescending:
+	default:
+		return nil, fmt.Errorf("sort_order MUST be asc or desc")
+	}
 	return conf, nil
 }
@@ -3,30 +3,53 @@ package config
 import (
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Streamlet/NoteIsSite/util"
+	"github.com/alecthomas/chroma/v2/styles"
 )
 
 type SiteConfig struct {
-	Server   ServerConfig   `toml:"server"`
-	Template TemplateConfig `toml:"template"`
-	Note     NoteConfig     `tomp:"note"`
+	Site       SiteInfoConfig   `toml:"site"`
+	Server     ServerConfig     `toml:"server"`
+	Template   TemplateConfig   `toml:"template"`
+	Note       NoteConfig       `tomp:"note"`
+	Translator TranslatorConfig `toml:"translator"`
+	Feed       FeedConfig       `toml:"feed"`
+	Sitemap    SitemapConfig    `toml:"sitemap"`
+	Robots     RobotsConfig     `toml:"robots"`
+	Search     SearchConfig     `toml:"search"`
+	Taxonomy   TaxonomyConfig   `toml:"taxonomy"`
+	Preview    PreviewConfig    `toml:"preview"`
+	TOC        TOCConfig        `toml:"toc"`
+	Markdown   MarkdownConfig   `toml:"markdown"`
+}
+
+type SiteInfoConfig struct {
+	BaseUrl string `toml:"base_url"` // optional, e.g. "https://example.com", for absolute urls in generated files
+	Title   string `toml:"title"`    // optional
 }
 
 type ServerConfig struct {
-	Port uint   `toml:"port"` // If port is specified, sock MUST be empty string.
-	Sock string `toml:"sock"` // sIf sock is specified, port MUST be 0.
+	Port   uint   `toml:"port"`   // If port is specified, sock MUST be empty string.
+	Sock   string `toml:"sock"`   // sIf sock is specified, port MUST be 0.
+	Status bool   `toml:"status"` // optional, serves /status.json reporting problems of the note tree
 }
 
 type TemplateConfig struct {
-	TemplateRoot     string   `toml:"template_root"`
-	StaticDirs       []string `toml:"static_dirs"`
-	IndexTemplate    string   `toml:"index_template"`
-	CategoryTemplate string   `toml:"category_template"`
-	ContentTemplate  string   `toml:"content_template"`
-	ErrorPage404     string   `toml:"404"` // optional
-	ErrorPage500     string   `toml:"500"` // optional
+	TemplateRoot       string   `toml:"template_root"`
+	StaticDirs         []string `toml:"static_dirs"`
+	IndexTemplate      string   `toml:"index_template"`
+	CategoryTemplate   string   `toml:"category_template"`
+	ContentTemplate    string   `toml:"content_template"`
+	SearchTemplate     string   `toml:"search_template"`      // optional
+	TaxonomyTemplate   string   `toml:"taxonomy_template"`    // optional
+	ArchiveTemplate    string   `toml:"archive_template"`     // optional
+	PartialsDir        string   `toml:"partials_dir"`         // optional
+	ErrorPage404       string   `toml:"404"`                  // optional
+	ErrorPage500       string   `toml:"500"`                  // optional
+	LegacyTextTemplate bool     `toml:"legacy_text_template"` // optional, executes by text/template without escaping
 }
 
 type NoteConfig struct {
@@ -35,16 +58,105 @@ type NoteConfig struct {
 	ResourceConfigFile string `toml:"resource_config_file"`
 	NoteFilePattern    string `toml:"note_file_pattern"`
 	NoteFileRegExp     *regexp.Regexp
+	WatchQuietPeriod   uint   `toml:"watch_quiet_period"` // in milliseconds, optional
+	RenderCacheSize    uint   `toml:"render_cache_size"`  // in megabytes, 0 to disable, optional
+	UriConflict        string `toml:"uri_conflict"`       // optional, policy for files with the same uri
+}
+
+type TranslatorConfig struct {
+	Extensions map[string]string          `toml:"extensions"` // optional, extension to translator name
+	External   []ExternalTranslatorConfig `toml:"external"`   // optional
 }
 
+type ExternalTranslatorConfig struct {
+	Name          string   `toml:"name"`
+	Command       []string `toml:"command"`
+	Extensions    []string `toml:"extensions"`
+	Timeout       uint     `toml:"timeout"`         // in seconds, optional
+	MaxOutputSize uint     `toml:"max_output_size"` // in megabytes, optional
+}
+
+type FeedConfig struct {
+	Enabled     bool `toml:"enabled"`      // optional, defaults to true if site.base_url is set
+	Items       uint `toml:"items"`        // optional, max number of notes in a feed
+	FullContent bool `toml:"full_content"` // optional, outputs full content of notes instead of summaries
+}
+
+type SitemapConfig struct {
+	Enabled bool `toml:"enabled"` // optional, defaults to true if site.base_url is set
+}
+
+type RobotsConfig struct {
+	Enabled bool   `toml:"enabled"` // optional, defaults to true
+	Rules   string `toml:"rules"`   // optional, content of robots.txt before the sitemap line
+}
+
+type SearchConfig struct {
+	Enabled    bool `toml:"enabled"`     // optional, defaults to true
+	MaxResults uint `toml:"max_results"` // optional
+}
+
+type TaxonomyConfig struct {
+	Names []string `toml:"names"` // optional, front matter fields classifying notes
+}
+
+type PreviewConfig struct {
+	Enabled bool   `toml:"enabled"` // optional, shows unpublished notes to everyone
+	Secret  string `toml:"secret"`  // optional, key to sign preview tokens
+}
+
+type TOCConfig struct {
+	MinLevel   uint `toml:"min_level"`  // optional, defaults to 1, headings above it are not in table of contents
+	MaxLevel   uint `toml:"max_level"`  // optional, defaults to 6, headings below it are not in table of contents
+	Permalinks bool `toml:"permalinks"` // optional, renders headings with anchors linking to themselves
+}
+
+type MarkdownConfig struct {
+	Footnotes        bool   `toml:"footnotes"`         // optional, e.g. "text[^1]" and "[^1]: note"
+	DefinitionLists  bool   `toml:"definition_lists"`  // optional, a term followed by ": definition" lines
+	Typographer      bool   `toml:"typographer"`       // optional, e.g. "--" to "–", straight quotes to curly ones
+	Attributes       bool   `toml:"attributes"`        // optional, e.g. "## heading {#id .class}"
+	Unsafe           bool   `toml:"unsafe"`            // optional, outputs raw HTML in notes instead of omitting it
+	HardWraps        bool   `toml:"hard_wraps"`        // optional, renders line breaks in paragraphs as <br>
+	HighlightStyle   string `toml:"highlight_style"`   // optional, chroma style of code blocks, defaults to "vs"
+	LineNumbers      bool   `toml:"line_numbers"`      // optional, defaults to true
+	HighlightClasses bool   `toml:"highlight_classes"` // optional, outputs css classes styled by /highlight.css
+	Math             string `toml:"math"`              // optional, renders $...$ and $$...$$ as math, off if empty
+}
+
+const (
+	MathMathML = "mathml" // converted to MathML on the server
+	MathClient = "client" // kept in \(...\) and \[...\] for client-side renderers like KaTeX and MathJax
+)
+
+const (
+	defaultPartialsDir           = "partials"
+	defaultWatchQuietPeriod      = 300
+	defaultRenderCacheSize       = 64
+	defaultExternalTimeout       = 10
+	defaultExternalMaxOutputSize = 16
+	defaultFeedItems             = 20
+	defaultSearchMaxResults      = 50
+	defaultRobotsRules           = "User-agent: *\nAllow: /\n"
+	defaultHighlightStyle        = "vs"
+)
+
+const (
+	UriConflictFirst  = "first"  // the file first in name order keeps the uri, others are dropped
+	UriConflictError  = "error"  // fails to build the site
+	UriConflictSuffix = "suffix" // others get "-2", "-3", etc. appended to their uris
+)
+
 var siteConfig *SiteConfig
 
 func LoadSiteConfig(configPath string) error {
 	util.Assert(siteConfig == nil, "duplicate loading site config")
 	conf := new(SiteConfig)
-	if _, err := toml.DecodeFile(configPath, conf); err != nil {
+	meta, err := toml.DecodeFile(configPath, conf)
+	if err != nil {
 		return err
 	}
+	conf.Site.BaseUrl = strings.TrimSuffix(conf.Site.BaseUrl, "/")
 	if conf.Server.Port == 0 && conf.Server.Sock == "" {
 		return fmt.Errorf("server.port or sock MUST be set")
 	}
@@ -63,6 +175,9 @@ func LoadSiteConfig(configPath string) error {
 	if conf.Template.ContentTemplate == "" {
 		return fmt.Errorf("template.content_template MUST be set")
 	}
+	if conf.Template.PartialsDir == "" {
+		conf.Template.PartialsDir = defaultPartialsDir
+	}
 	if conf.Note.NoteRoot == "" {
 		return fmt.Errorf("note.note_root MUST be set")
 	}
@@ -80,6 +195,80 @@ func LoadSiteConfig(configPath string) error {
 		return err
 	}
 	conf.Note.NoteFileRegExp = regex
+	if conf.Note.WatchQuietPeriod == 0 {
+		conf.Note.WatchQuietPeriod = defaultWatchQuietPeriod
+	}
+	if !meta.IsDefined("note", "render_cache_size") {
+		conf.Note.RenderCacheSize = defaultRenderCacheSize
+	}
+	switch conf.Note.UriConflict {
+	case "":
+		conf.Note.UriConflict = UriConflictFirst
+	case UriConflictFirst, UriConflictError, UriConflictSuffix:
+	default:
+		return fmt.Errorf("note.uri_conflict MUST be one of first, error and suffix")
+	}
+	for i := range conf.Translator.External {
+		if conf.Translator.External[i].Timeout == 0 {
+			conf.Translator.External[i].Timeout = defaultExternalTimeout
+		}
+		if conf.Translator.External[i].MaxOutputSize == 0 {
+			conf.Translator.External[i].MaxOutputSize = defaultExternalMaxOutputSize
+		}
+	}
+	if !meta.IsDefined("feed", "enabled") {
+		// atom requires absolute urls for ids and links
+		conf.Feed.Enabled = conf.Site.BaseUrl != ""
+	} else if conf.Feed.Enabled && conf.Site.BaseUrl == "" {
+		return fmt.Errorf("site.base_url MUST be set if feed is enabled")
+	}
+	if conf.Feed.Items == 0 {
+		conf.Feed.Items = defaultFeedItems
+	}
+	if !meta.IsDefined("sitemap", "enabled") {
+		// the sitemap protocol requires absolute urls
+		conf.Sitemap.Enabled = conf.Site.BaseUrl != ""
+	} else if conf.Sitemap.Enabled && conf.Site.BaseUrl == "" {
+		return fmt.Errorf("site.base_url MUST be set if sitemap is enabled")
+	}
+	if !meta.IsDefined("robots", "enabled") {
+		conf.Robots.Enabled = true
+	}
+	if !meta.IsDefined("robots", "rules") {
+		conf.Robots.Rules = defaultRobotsRules
+	}
+	if !meta.IsDefined("search", "enabled") {
+		conf.Search.Enabled = true
+	}
+	if conf.Search.MaxResults == 0 {
+		conf.Search.MaxResults = defaultSearchMaxResults
+	}
+	if !meta.IsDefined("taxonomy", "names") {
+		conf.Taxonomy.Names = []string{"tags"}
+	}
+	if conf.TOC.MinLevel == 0 {
+		conf.TOC.MinLevel = 1
+	}
+	if conf.TOC.MaxLevel == 0 {
+		conf.TOC.MaxLevel = 6
+	}
+	if conf.TOC.MinLevel > conf.TOC.MaxLevel || conf.TOC.MaxLevel > 6 {
+		return fmt.Errorf("toc.min_level and toc.max_level MUST be between 1 and 6")
+	}
+	if conf.Markdown.HighlightStyle == "" {
+		conf.Markdown.HighlightStyle = defaultHighlightStyle
+	}
+	if _, ok := styles.Registry[conf.Markdown.HighlightStyle]; !ok {
+		return fmt.Errorf("markdown.highlight_style MUST be a chroma style, e.g. vs, github and monokai")
+	}
+	switch conf.Markdown.Math {
+	case "", MathMathML, MathClient:
+	default:
+		return fmt.Errorf("markdown.math MUST be mathml or client")
+	}
+	if !meta.IsDefined("markdown", "line_numbers") {
+		conf.Markdown.LineNumbers = true
+	}
 	siteConfig = conf
 	return nil
 }
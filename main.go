@@ -1,32 +1,129 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/Streamlet/NoteIsSite/config"
 	"github.com/Streamlet/NoteIsSite/global"
+	"github.com/Streamlet/NoteIsSite/note"
 	"github.com/Streamlet/NoteIsSite/server"
 	"github.com/Streamlet/NoteIsSite/util"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 )
 
 type commandLineArgs struct {
-	config string
+	command string
+	config  string
+	output  string
+	expire  time.Duration
 }
 
+const usage = `Usage: %s [command] [options]
+
+Commands:
+  serve    serve the site dynamically (default)
+  build    render the whole site into static files
+  check    report broken links and other problems in json, exiting with 1 if there are any errors
+  preview-token
+           make a token to preview unpublished notes by "?preview=<token>"
+
+Options:
+`
+
 func main() {
 	var args commandLineArgs
+	args.command = "serve"
+	argv := os.Args[1:]
+	if len(argv) > 0 && !strings.HasPrefix(argv[0], "-") {
+		args.command, argv = argv[0], argv[1:]
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.StringVar(&args.config, "config", "site.toml", "config file path")
-	flag.Parse()
+	flag.StringVar(&args.output, "output", "public", "output directory for build command")
+	flag.DurationVar(&args.expire, "expire", 24*time.Hour, "valid duration of tokens made by preview-token command")
+	_ = flag.CommandLine.Parse(argv)
 
 	err := config.LoadSiteConfig(args.config)
 	if err != nil {
 		fmt.Printf("failed to load %s: %s\n", args.config, err.Error())
-		return
+		os.Exit(1)
 	}
+
+	switch args.command {
+	case "serve":
+		serve()
+	case "build":
+		if err := build(args.output); err != nil {
+			fmt.Printf("failed to build: %s.\n", err.Error())
+			os.Exit(1)
+		}
+	case "check":
+		errors, err := check()
+		if err != nil {
+			fmt.Printf("failed to check: %s.\n", err.Error())
+			os.Exit(1)
+		}
+		if errors > 0 {
+			os.Exit(1)
+		}
+	case "preview-token":
+		token, err := server.PreviewToken(time.Now().Add(args.expire))
+		if err != nil {
+			fmt.Printf("failed to make preview token: %s.\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(token)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func build(output string) error {
+	conf := config.GetSiteConfig()
+	// static sites have no search pages
+	conf.Search.Enabled = false
+	notesRouter, err := note.NewBuildRouter(conf.Note.NoteRoot, conf.Template.TemplateRoot)
+	if err != nil {
+		return err
+	}
+	defer notesRouter.Close()
+
+	if err := notesRouter.Export(output); err != nil {
+		return err
+	}
+	fmt.Printf("Site built into %s\n", output)
+	return nil
+}
+
+func check() (int, error) {
+	conf := config.GetSiteConfig()
+	notesRouter, err := note.NewCheckRouter(conf.Note.NoteRoot, conf.Template.TemplateRoot)
+	if err != nil {
+		return 0, err
+	}
+	defer notesRouter.Close()
+
+	report := notesRouter.Check()
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	fmt.Println(string(content))
+	return report.Errors, nil
+}
+
+func serve() {
 	conf := config.GetSiteConfig()
+	var err error
 
 	var srv server.HttpServer
 	if conf.Server.Sock != "" {
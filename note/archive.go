@@ -0,0 +1,137 @@
+package note
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/template"
+)
+
+// archiveGenerator generates archive pages by the archive template, /archive/ listing all notes by date,
+// /archive/<year>/ and /archive/<year>/<month>/ listing notes in the year or month
+type archiveGenerator struct {
+	nr *notesRouter
+}
+
+const archiveRootUri = "/archive/"
+
+func (g archiveGenerator) uris() []string {
+	uris := []string{archiveRootUri}
+	found := make(map[string]bool)
+	root, ok := g.nr.pathNodeMap[g.nr.noteRoot]
+	if !ok {
+		return uris
+	}
+	for _, date := range archiveDates(root, make(map[string]time.Time)) {
+		for _, uri := range []string{archiveUri(date.Year(), 0), archiveUri(date.Year(), int(date.Month()))} {
+			if !found[uri] {
+				found[uri] = true
+				uris = append(uris, uri)
+			}
+		}
+	}
+	return uris
+}
+
+func (g archiveGenerator) generate(uri string) ([]byte, string, bool, error) {
+	if !strings.HasPrefix(uri, archiveRootUri) {
+		return nil, "", false, nil
+	}
+	year, month := 0, 0
+	if parts := strings.Split(strings.Trim(strings.TrimPrefix(uri, archiveRootUri), "/"), "/"); parts[0] != "" {
+		var err error
+		if year, err = strconv.Atoi(parts[0]); err != nil || len(parts) > 2 {
+			return nil, "", false, nil
+		}
+		if len(parts) > 1 {
+			if month, err = strconv.Atoi(parts[1]); err != nil || month < 1 || month > 12 {
+				return nil, "", false, nil
+			}
+		}
+	}
+	// only the canonical form, e.g. /archive/2006/01/, not /archive/2006/1
+	if archiveUri(year, month) != uri {
+		return nil, "", false, nil
+	}
+
+	g.nr.lock.RLock()
+	root, ok := g.nr.pathNodeMap[g.nr.noteRoot]
+	var pageData *template.PageData
+	var dates map[string]time.Time
+	if ok {
+		pageData = root.toPageData(false)
+		dates = archiveDates(root, make(map[string]time.Time))
+	}
+	g.nr.lock.RUnlock()
+	if pageData == nil {
+		return nil, "", false, nil
+	}
+
+	data := template.ArchivePageData{PageData: *pageData, Year: year, Month: month}
+	items := make([]*template.ArchiveItem, 0, len(dates))
+	var walk func(item *template.BasicItem)
+	walk = func(item *template.BasicItem) {
+		if date, ok := dates[item.Uri]; ok && item.Children == nil {
+			items = append(items, &template.ArchiveItem{BasicItem: item, Date: date})
+		}
+		for _, c := range item.Children {
+			walk(c)
+		}
+	}
+	walk(pageData.BasicItem)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Date.After(items[j].Date)
+	})
+
+	data.Items = make([]*template.ArchiveItem, 0)
+	data.Years = make([]*template.ArchiveYear, 0)
+	for _, item := range items {
+		y, m := item.Date.Year(), int(item.Date.Month())
+		if len(data.Years) == 0 || data.Years[len(data.Years)-1].Year != y {
+			data.Years = append(data.Years, &template.ArchiveYear{Year: y, Uri: archiveUri(y, 0)})
+		}
+		archiveYear := data.Years[len(data.Years)-1]
+		archiveYear.Count++
+		if len(archiveYear.Months) == 0 || archiveYear.Months[len(archiveYear.Months)-1].Month != m {
+			archiveYear.Months = append(archiveYear.Months, &template.ArchiveMonth{Month: m, Uri: archiveUri(y, m)})
+		}
+		archiveYear.Months[len(archiveYear.Months)-1].Count++
+		if (year == 0 || year == y) && (month == 0 || month == m) {
+			data.Items = append(data.Items, item)
+		}
+	}
+	if year != 0 && len(data.Items) == 0 {
+		return nil, "", false, nil
+	}
+	content, err := g.nr.templateExecutor.GetArchive(data)
+	return content, "text/html", true, err
+}
+
+// archiveDates collects dates of public notes in category n and its sub categories except excluded ones, keyed by uri
+func archiveDates(n *node, dates map[string]time.Time) map[string]time.Time {
+	if n.excludeFromArchive {
+		return dates
+	}
+	for _, c := range n.subItems {
+		if c.isDir {
+			archiveDates(c, dates)
+		} else if !c.unpublished {
+			dates[c.absoluteUri] = c.date()
+		}
+	}
+	return dates
+}
+
+// archiveUri returns uri of the archive page of year and month, 0 for all years or a whole year
+func archiveUri(year int, month int) string {
+	if year == 0 {
+		return archiveRootUri
+	}
+	if month == 0 {
+		return fmt.Sprintf("%s%d/", archiveRootUri, year)
+	}
+	return fmt.Sprintf("%s%d/%02d/", archiveRootUri, year, month)
+}
@@ -0,0 +1,75 @@
+package note
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// renderCache keeps rendered output of uris and generated files in memory, evicting the least recently used ones beyond capacity.
+// An entry is valid only if its source file, the note tree and the templates are all unchanged since rendering.
+type renderCache struct {
+	lock     sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+type cacheEntry struct {
+	key             string
+	mimeType        string
+	modTime         time.Time
+	treeVersion     uint64
+	templateVersion uint64
+	content         []byte
+}
+
+func newRenderCache(capacity int64) *renderCache {
+	c := new(renderCache)
+	c.capacity = capacity
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+	return c
+}
+
+func (c *renderCache) get(key string, modTime time.Time, treeVersion uint64, templateVersion uint64) (*cacheEntry, bool) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !entry.modTime.Equal(modTime) || entry.treeVersion != treeVersion || entry.templateVersion != templateVersion {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry, true
+}
+
+func (c *renderCache) put(entry *cacheEntry) {
+	if int64(len(entry.content)) > c.capacity {
+		return
+	}
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	if e, ok := c.entries[entry.key]; ok {
+		c.remove(e)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += int64(len(entry.content))
+	for c.size > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *renderCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.content))
+}
@@ -0,0 +1,339 @@
+package note
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/Streamlet/NoteIsSite/note/translator"
+)
+
+// Issue is a problem in notes or configs found by Check
+type Issue struct {
+	Kind    string `json:"kind"`
+	Level   string `json:"level"`
+	Path    string `json:"path,omitempty"`   // file in question
+	Uri     string `json:"uri,omitempty"`    // page in question
+	Target  string `json:"target,omitempty"` // link, resource, or the other file with the same uri
+	Message string `json:"message"`
+}
+
+// CheckReport lists all issues found by Check, sorted by path
+type CheckReport struct {
+	Pages  int      `json:"pages"`
+	Errors int      `json:"errors"`
+	Issues []*Issue `json:"issues"`
+}
+
+const (
+	IssueBrokenLink      = "broken_link"
+	IssueMissingResource = "missing_resource"
+	IssueMissingAnchor   = "missing_anchor"
+	IssueDuplicateUri    = "duplicate_uri"
+	IssueUnmatchedFile   = "unmatched_file"
+	IssueInvalidConfig   = "invalid_config"
+	IssueRenderError     = "render_error"
+)
+
+const (
+	LevelError = "error"
+	// warnings may be intended, e.g. files not matching the note file pattern are hidden on purpose
+	LevelWarning = "warning"
+)
+
+var idRegExp = regexp.MustCompile(`(?i)\sid\s*=\s*["']([^"']*)["']`)
+
+// addIssue records an issue found while building the tree, called with the tree locked
+func (nr *notesRouter) addIssue(issue *Issue) {
+	nr.issues[issue.Path] = append(nr.issues[issue.Path], issue)
+}
+
+// checkConfig records an issue if the config file in dir exists but fails to load
+func (nr *notesRouter) checkConfig(dir string, name string, err error) {
+	if err != nil && !os.IsNotExist(err) {
+		nr.addIssue(&Issue{Kind: IssueInvalidConfig, Level: LevelError, Path: filepath.Join(dir, name), Message: err.Error()})
+	}
+}
+
+// register maps uri of n. If another file has the uri already, the conflict is recorded and resolved by the uri
+// conflict policy, returning false if n is dropped. Called with the tree locked.
+func (nr *notesRouter) register(n *node) bool {
+	// static and resource directories have no pages
+	hasPage := func(n *node) bool {
+		return n.isNote || !n.isDir
+	}
+	other, ok := nr.uriNodeMap[n.absoluteUri]
+	if !ok || other.absolutePath == n.absolutePath || !hasPage(other) || !hasPage(n) {
+		nr.uriNodeMap[n.absoluteUri] = n
+		if page, reserved := reservedUris()[n.absoluteUri]; reserved && hasPage(n) {
+			level := LevelWarning
+			if config.GetSiteConfig().Note.UriConflict == config.UriConflictError {
+				level = LevelError
+			}
+			log.Printf("uri %s of %s hides the %s page\n", n.absoluteUri, n.absolutePath, page)
+			nr.addIssue(&Issue{Kind: IssueDuplicateUri, Level: level, Path: n.absolutePath, Uri: n.absoluteUri,
+				Message: "uri is the same as the " + page + " page, which is hidden"})
+		}
+		return true
+	}
+
+	issue := &Issue{Kind: IssueDuplicateUri, Level: LevelError, Path: n.absolutePath, Uri: n.absoluteUri, Target: other.absolutePath}
+	if config.GetSiteConfig().Note.UriConflict != config.UriConflictSuffix {
+		issue.Message = "uri is the same as the other file, which is kept"
+		log.Printf("uri %s of %s conflicts with %s, which is kept\n", n.absoluteUri, n.absolutePath, other.absolutePath)
+		nr.addIssue(issue)
+		return false
+	}
+	for i := 2; ; i++ {
+		uri := suffixedUri(n.absoluteUri, i, n.isNote)
+		if _, ok := nr.uriNodeMap[uri]; !ok {
+			n.absoluteUri = uri
+			break
+		}
+	}
+	issue.Level = LevelWarning
+	issue.Message = "uri is the same as the other file, renamed to " + n.absoluteUri
+	log.Printf("uri %s of %s conflicts with %s, renamed to %s\n", issue.Uri, n.absolutePath, other.absolutePath, n.absoluteUri)
+	nr.addIssue(issue)
+	nr.uriNodeMap[n.absoluteUri] = n
+	return true
+}
+
+// suffixedUri disambiguates uri by "-i", before the extension of resources and static files
+func suffixedUri(uri string, i int, isNote bool) string {
+	suffix := "-" + strconv.Itoa(i)
+	if strings.HasSuffix(uri, "/") {
+		return strings.TrimSuffix(uri, "/") + suffix + "/"
+	}
+	if ext := path.Ext(uri); !isNote && ext != "" {
+		return strings.TrimSuffix(uri, ext) + suffix + ext
+	}
+	return uri + suffix
+}
+
+// checkConflicts fails building the tree if there are conflicting uris and the uri conflict policy is error, called
+// with the tree locked
+func (nr *notesRouter) checkConflicts() error {
+	if config.GetSiteConfig().Note.UriConflict != config.UriConflictError || nr.mode == modeCheck {
+		return nil
+	}
+	var conflict *Issue
+	for _, issues := range nr.issues {
+		for _, issue := range issues {
+			if issue.Kind == IssueDuplicateUri && (conflict == nil || issue.Path < conflict.Path) {
+				conflict = issue
+			}
+		}
+	}
+	if conflict == nil {
+		return nil
+	}
+	if conflict.Target == "" {
+		return fmt.Errorf("uri %s of %s: %s", conflict.Uri, conflict.Path, conflict.Message)
+	}
+	return fmt.Errorf("uri %s of %s conflicts with %s", conflict.Uri, conflict.Path, conflict.Target)
+}
+
+// dirsOfDroppedNodes returns directories of files dropped or renamed for conflicting uris, whose files kept are not
+// in the tree anymore, called with the tree locked
+func (nr *notesRouter) dirsOfDroppedNodes() map[string]bool {
+	dirs := make(map[string]bool)
+	for _, issues := range nr.issues {
+		for _, issue := range issues {
+			if issue.Kind != IssueDuplicateUri || issue.Target == "" {
+				continue
+			}
+			if other, ok := nr.uriNodeMap[issue.Uri]; !ok || other.absolutePath != issue.Target {
+				dirs[filepath.Dir(issue.Path)] = true
+			}
+		}
+	}
+	return dirs
+}
+
+// reservedUris maps uris served after notes by the server to names of their pages
+func reservedUris() map[string]string {
+	uris := make(map[string]string)
+	if config.GetSiteConfig().Search.Enabled {
+		uris[SearchUri] = "search"
+		uris[SearchJSONUri] = "search"
+	}
+	if config.GetSiteConfig().Server.Status {
+		uris[StatusUri] = "status"
+	}
+	return uris
+}
+
+func isHiddenOrConfig(name string) bool {
+	c := config.GetSiteConfig().Note
+	return strings.HasPrefix(name, ".") || name == c.CategoryConfigFile || name == c.ResourceConfigFile
+}
+
+type statusJSON struct {
+	Pages     int      `json:"pages"`
+	Version   uint64   `json:"version"`
+	Conflicts int      `json:"conflicts"`
+	Issues    []*Issue `json:"issues"`
+}
+
+func (nr *notesRouter) Status() ([]byte, string, error) {
+	nr.lock.RLock()
+	status := &statusJSON{Pages: len(nr.uriNodeMap), Version: nr.version, Issues: make([]*Issue, 0)}
+	for _, issues := range nr.issues {
+		for _, issue := range issues {
+			status.Issues = append(status.Issues, issue)
+			if issue.Kind == IssueDuplicateUri {
+				status.Conflicts++
+			}
+		}
+	}
+	nr.lock.RUnlock()
+
+	sortIssues(status.Issues)
+	content, err := json.Marshal(status)
+	if err != nil {
+		return nr.templateExecutor.Get500(), "", err
+	}
+	return content, "application/json", nil
+}
+
+// checkedPage is a page with its source file translated for checking
+type checkedPage struct {
+	uri  string
+	path string
+	doc  *translator.Document
+	ids  map[string]bool
+}
+
+func (nr *notesRouter) Check() *CheckReport {
+	report := &CheckReport{Issues: make([]*Issue, 0)}
+
+	nr.lock.RLock()
+	for _, issues := range nr.issues {
+		report.Issues = append(report.Issues, issues...)
+	}
+	notes := make(map[string]*node)
+	for uri, n := range nr.uriNodeMap {
+		if n.isNote && !n.unpublished && n.sourcePath() != "" {
+			notes[uri] = n
+		}
+	}
+	treeVersion := nr.version
+	nr.lock.RUnlock()
+
+	// rendering pages catches errors of templates as well, and sources of notes are translated once for both
+	// rendering and checking links
+	pages := make(map[string]*checkedPage)
+	for _, uri := range nr.uris() {
+		report.Pages++
+		n, ok := notes[uri]
+		if !ok {
+			if _, _, err := nr.Route((&url.URL{Path: uri}).EscapedPath(), false); err != nil {
+				report.Issues = append(report.Issues, &Issue{Kind: IssueRenderError, Level: LevelError, Uri: uri, Message: err.Error()})
+			}
+			continue
+		}
+		doc, err := translator.New(n.sourcePath()).Translate()
+		if err == nil {
+			page := &checkedPage{uri, n.sourcePath(), doc, make(map[string]bool)}
+			for _, m := range idRegExp.FindAllSubmatch(doc.HTML, -1) {
+				page.ids[string(m[1])] = true
+			}
+			pages[uri] = page
+			_, _, err = nr.render(n, uri, treeVersion, false, doc)
+		}
+		if err != nil {
+			report.Issues = append(report.Issues, &Issue{Kind: IssueRenderError, Level: LevelError, Path: n.sourcePath(),
+				Uri: uri, Message: err.Error()})
+		}
+	}
+	// a link to the same target in a note is reported once
+	type linkIssue struct {
+		path, kind, target string
+	}
+	reported := make(map[linkIssue]bool)
+	addLinkIssue := func(issue *Issue) {
+		if key := (linkIssue{issue.Path, issue.Kind, issue.Target}); !reported[key] {
+			reported[key] = true
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	for _, page := range pages {
+		for _, link := range page.doc.Links {
+			if issue := nr.checkLink(page, link, false, pages); issue != nil {
+				addLinkIssue(issue)
+			}
+		}
+		for _, asset := range page.doc.Assets {
+			if issue := nr.checkLink(page, asset, true, pages); issue != nil {
+				addLinkIssue(issue)
+			}
+		}
+	}
+
+	sortIssues(report.Issues)
+	for _, issue := range report.Issues {
+		if issue.Level == LevelError {
+			report.Errors++
+		}
+	}
+	return report
+}
+
+func sortIssues(issues []*Issue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Target < b.Target
+	})
+}
+
+// checkLink returns an issue if link in page refers to nothing in the site, or to an anchor not in the page
+func (nr *notesRouter) checkLink(page *checkedPage, link string, isAsset bool, pages map[string]*checkedPage) *Issue {
+	issue := func(kind string, message string) *Issue {
+		return &Issue{Kind: kind, Level: LevelError, Path: page.path, Uri: page.uri, Target: link, Message: message}
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return issue(IssueBrokenLink, err.Error())
+	}
+	if u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		// external links are not checked
+		return nil
+	}
+
+	target := page
+	if u.Path != "" {
+		uri := strings.ToLower((&url.URL{Path: page.uri}).ResolveReference(&url.URL{Path: u.Path}).Path)
+		nr.lock.RLock()
+		n, ok := nr.uriNodeMap[uri]
+		found := (ok && !n.unpublished) || nr.isGenerated(uri)
+		nr.lock.RUnlock()
+		if !found {
+			if isAsset {
+				return issue(IssueMissingResource, "resource not found")
+			}
+			return issue(IssueBrokenLink, "page not found")
+		}
+		target = pages[uri]
+	}
+	// anchors are checked only in notes
+	if u.Fragment != "" && target != nil && !target.ids[u.Fragment] {
+		return issue(IssueMissingAnchor, fmt.Sprintf("anchor #%s not found in %s", u.Fragment, target.uri))
+	}
+	return nil
+}
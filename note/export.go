@@ -0,0 +1,181 @@
+package note
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Export renders all uris into outputDir, which can be deployed as a static site. The site is rendered into a
+// temporary directory beside outputDir first, which replaces outputDir then, so that files of notes removed since
+// last export are gone as well.
+func (nr *notesRouter) Export(outputDir string) error {
+	if err := nr.checkOutputDir(outputDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(outputDir)), 0755); err != nil {
+		return err
+	}
+	tempDir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(outputDir)), "."+filepath.Base(outputDir)+"-")
+	if err != nil {
+		return err
+	}
+	if err := nr.export(tempDir); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+	if err := os.RemoveAll(outputDir); err != nil {
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+	return os.Rename(tempDir, outputDir)
+}
+
+// checkOutputDir refuses to replace directories holding notes, templates, or the working directory, and to export
+// into notes or templates, which would be a part of the site then
+func (nr *notesRouter) checkOutputDir(outputDir string) error {
+	output, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{nr.noteRoot, nr.templateRoot, wd} {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		if isSubPath(dir, output) {
+			return fmt.Errorf("output directory %s MUST NOT contain %s", outputDir, dir)
+		}
+		if dir != wd && isSubPath(output, dir) {
+			return fmt.Errorf("output directory %s MUST NOT be in %s", outputDir, dir)
+		}
+	}
+	return nil
+}
+
+func (nr *notesRouter) export(outputDir string) error {
+	// pages show backlinks, which are found by indexing
+	nr.index()
+	type page struct {
+		uri      string
+		mimeType string
+		content  []byte
+	}
+	var pages []page
+	exported := make(map[string]string)
+	for _, uri := range nr.uris() {
+		content, mimeType, err := nr.Route((&url.URL{Path: uri}).EscapedPath(), false)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %s", uri, err.Error())
+		}
+		pages = append(pages, page{uri, mimeType, content})
+		exported[uri] = exportPath(uri, mimeType)
+	}
+	// links are rewritten after all pages are rendered, when the file names of all uris are known
+	for _, p := range pages {
+		content := p.content
+		if p.mimeType == "text/html" {
+			content = exportLinks(content, exported[p.uri], exported)
+		}
+		if err := writeFile(filepath.Join(outputDir, filepath.FromSlash(exported[p.uri])), content); err != nil {
+			return err
+		}
+	}
+	if err404 := nr.templateExecutor.Get404(); len(err404) > 0 {
+		if err := writeFile(filepath.Join(outputDir, "404.html"), err404); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uris returns all uris in the site, including generated ones, sorted
+func (nr *notesRouter) uris() []string {
+	defer nr.lock.RUnlock()
+	nr.lock.RLock()
+
+	uris := make([]string, 0, len(nr.uriNodeMap))
+	for uri, n := range nr.uriNodeMap {
+		if !n.unpublished {
+			uris = append(uris, uri)
+		}
+	}
+	for _, g := range nr.generators {
+		for _, uri := range g.uris() {
+			if _, ok := nr.uriNodeMap[uri]; !ok {
+				uris = append(uris, uri)
+			}
+		}
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// exportPath maps uri to file path relative to output directory. Directory uris are written as index.html in them,
+// and pages without html extension get one, which most static hosts look up for extensionless uris.
+func exportPath(uri string, mimeType string) string {
+	if strings.HasSuffix(uri, "/") {
+		return uri + "index.html"
+	}
+	if ext := strings.ToLower(path.Ext(uri)); mimeType == "text/html" && ext != ".html" && ext != ".htm" {
+		return uri + ".html"
+	}
+	return uri
+}
+
+var linkAttrRegExp = regexp.MustCompile(`(\s(?:href|src)=")([^"]*)(")`)
+
+// exportLinks rewrites links to exported uris in html content into paths of their files, relative to the page, so that
+// the site works on static hosts which don't look up extensionless uris, and from file system as well.
+// Other links, like absolute urls and uris not exported, are kept as they are.
+func exportLinks(content []byte, file string, exported map[string]string) []byte {
+	return linkAttrRegExp.ReplaceAllFunc(content, func(attr []byte) []byte {
+		parts := linkAttrRegExp.FindSubmatch(attr)
+		link := html.UnescapeString(string(parts[2]))
+		if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") {
+			return attr
+		}
+		uri, suffix := link, ""
+		if i := strings.IndexAny(link, "?#"); i >= 0 {
+			uri, suffix = link[:i], link[i:]
+		}
+		uri, err := url.PathUnescape(uri)
+		if err != nil {
+			return attr
+		}
+		target, ok := exported[uri]
+		if !ok {
+			return attr
+		}
+		relative, err := filepath.Rel(filepath.FromSlash(path.Dir(file)), filepath.FromSlash(target))
+		if err != nil {
+			return attr
+		}
+		relative = (&url.URL{Path: filepath.ToSlash(relative)}).EscapedPath()
+		if i := strings.IndexAny(relative, ":/"); i >= 0 && relative[i] == ':' {
+			// not to be taken as a scheme
+			relative = "./" + relative
+		}
+		return []byte(string(parts[1]) + html.EscapeString(relative+suffix) + string(parts[3]))
+	})
+}
+
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,319 @@
+package note
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/Streamlet/NoteIsSite/note/translator"
+)
+
+// feedGenerator generates an atom feed and a rss 2.0 feed of the latest notes at the root and under each category
+type feedGenerator struct {
+	nr *notesRouter
+}
+
+const (
+	atomFeedName     = "feed.xml"
+	rssFeedName      = "rss.xml"
+	atomFeedMimeType = "application/atom+xml"
+	rssFeedMimeType  = "application/rss+xml"
+)
+
+type feedItem struct {
+	uri     string
+	path    string
+	title   string
+	meta    *translator.Metadata
+	date    time.Time
+	updated time.Time
+}
+
+func (g feedGenerator) uris() []string {
+	uris := make([]string, 0)
+	for uri, n := range g.nr.uriNodeMap {
+		if n.isDir && n.isNote {
+			uris = append(uris, uri+atomFeedName, uri+rssFeedName)
+		}
+	}
+	return uris
+}
+
+func (g feedGenerator) generate(uri string) ([]byte, string, bool, error) {
+	categoryUri, name := path.Split(uri)
+	if name != atomFeedName && name != rssFeedName {
+		return nil, "", false, nil
+	}
+
+	g.nr.lock.RLock()
+	n, ok := g.nr.uriNodeMap[categoryUri]
+	if !ok || !n.isDir || !n.isNote {
+		g.nr.lock.RUnlock()
+		return nil, "", false, nil
+	}
+	title := config.GetSiteConfig().Site.Title
+	if n.parent != nil {
+		if title != "" {
+			title += " - "
+		}
+		title += n.title()
+	}
+	if title == "" {
+		title = absoluteUrl(config.GetSiteConfig().Site.BaseUrl, categoryUri)
+	}
+	description := ""
+	if n.meta != nil {
+		description = n.meta.Description
+	}
+	items := collectFeedItems(n, make([]*feedItem, 0))
+	g.nr.lock.RUnlock()
+
+	// notes are translated out of the lock, as it may take long
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].date.After(items[j].date)
+	})
+	if max := int(config.GetSiteConfig().Feed.Items); len(items) > max {
+		items = items[:max]
+	}
+	if description == "" {
+		description = title
+	}
+
+	if name == atomFeedName {
+		content, err := atomFeed(categoryUri, title, description, items)
+		return content, atomFeedMimeType, true, err
+	} else {
+		content, err := rssFeed(categoryUri, title, description, items)
+		return content, rssFeedMimeType, true, err
+	}
+}
+
+// collectFeedItems collects all public notes in category n and its sub categories
+func collectFeedItems(n *node, items []*feedItem) []*feedItem {
+	for _, c := range n.subItems {
+		if c.isDir {
+			items = collectFeedItems(c, items)
+			continue
+		}
+		if c.unpublished {
+			continue
+		}
+		item := &feedItem{uri: c.absoluteUri, path: c.absolutePath, title: c.title(), meta: c.meta}
+		item.date = c.date()
+		item.updated = item.date
+		if c.meta != nil && c.meta.Lastmod != nil {
+			item.updated = *c.meta.Lastmod
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// title returns title in front matter if any, or display name of the node
+func (n *node) title() string {
+	if n.meta != nil && n.meta.Title != "" {
+		return n.meta.Title
+	}
+	return n.name
+}
+
+// date returns date in front matter if any, or modification time of the file
+func (n *node) date() time.Time {
+	if n.meta != nil && n.meta.Date != nil {
+		return *n.meta.Date
+	}
+	return n.modTime
+}
+
+// content returns summary or full content of the note in html according to the feed config
+func (item *feedItem) content() (string, error) {
+	fullContent := config.GetSiteConfig().Feed.FullContent
+	if !fullContent && item.meta != nil && item.meta.Description != "" {
+		return item.meta.Description, nil
+	}
+	doc, err := translator.New(item.path).Translate()
+	if err != nil {
+		return "", err
+	}
+	if fullContent {
+		return absoluteLinks(string(doc.HTML), config.GetSiteConfig().Site.BaseUrl, item.uri), nil
+	}
+	return doc.Summary, nil
+}
+
+// absoluteLinks resolves links in html content of the note at uri into absolute urls, as feed readers show the
+// content out of the site
+func absoluteLinks(content string, baseUrl string, uri string) string {
+	base, err := url.Parse(absoluteUrl(baseUrl, uri))
+	if err != nil {
+		return content
+	}
+	return linkAttrRegExp.ReplaceAllStringFunc(content, func(attr string) string {
+		parts := linkAttrRegExp.FindStringSubmatch(attr)
+		link, err := url.Parse(html.UnescapeString(parts[2]))
+		if err != nil || link.IsAbs() || link.Host != "" {
+			return attr
+		}
+		return parts[1] + html.EscapeString(base.ResolveReference(link).String()) + parts[3]
+	})
+}
+
+func (item *feedItem) author() string {
+	if item.meta == nil {
+		return ""
+	}
+	return item.meta.Author
+}
+
+type atomFeedXML struct {
+	XMLName  xml.Name        `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string          `xml:"title"`
+	Subtitle string          `xml:"subtitle,omitempty"`
+	ID       string          `xml:"id"`
+	Updated  string          `xml:"updated"`
+	Links    []atomLinkXML   `xml:"link"`
+	Entries  []*atomEntryXML `xml:"entry"`
+}
+
+type atomLinkXML struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntryXML struct {
+	Title     string         `xml:"title"`
+	ID        string         `xml:"id"`
+	Links     []atomLinkXML  `xml:"link"`
+	Published string         `xml:"published"`
+	Updated   string         `xml:"updated"`
+	Author    *atomAuthorXML `xml:"author,omitempty"`
+	Summary   *atomTextXML   `xml:"summary,omitempty"`
+	Content   *atomTextXML   `xml:"content,omitempty"`
+}
+
+type atomAuthorXML struct {
+	Name string `xml:"name"`
+}
+
+type atomTextXML struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+func atomFeed(categoryUri string, title string, description string, items []*feedItem) ([]byte, error) {
+	baseUrl := config.GetSiteConfig().Site.BaseUrl
+	feed := &atomFeedXML{Title: title, ID: absoluteUrl(baseUrl, categoryUri)}
+	if description != title {
+		feed.Subtitle = description
+	}
+	feed.Links = []atomLinkXML{
+		{Href: absoluteUrl(baseUrl, categoryUri)},
+		{Href: absoluteUrl(baseUrl, categoryUri+atomFeedName), Rel: "self", Type: atomFeedMimeType},
+	}
+	var updated time.Time
+	for _, item := range items {
+		content, err := item.content()
+		if err != nil {
+			return nil, err
+		}
+		entry := &atomEntryXML{
+			Title:     item.title,
+			ID:        absoluteUrl(baseUrl, item.uri),
+			Links:     []atomLinkXML{{Href: absoluteUrl(baseUrl, item.uri)}},
+			Published: item.date.Format(time.RFC3339),
+			Updated:   item.updated.Format(time.RFC3339),
+		}
+		if author := item.author(); author != "" {
+			entry.Author = &atomAuthorXML{author}
+		}
+		if config.GetSiteConfig().Feed.FullContent {
+			entry.Content = &atomTextXML{"html", content}
+		} else {
+			entry.Summary = &atomTextXML{"text", content}
+		}
+		feed.Entries = append(feed.Entries, entry)
+		if item.updated.After(updated) {
+			updated = item.updated
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+	return marshalXML(feed)
+}
+
+type rssFeedXML struct {
+	XMLName xml.Name      `xml:"rss"`
+	Version string        `xml:"version,attr"`
+	Channel rssChannelXML `xml:"channel"`
+}
+
+type rssChannelXML struct {
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	Description   string        `xml:"description"`
+	LastBuildDate string        `xml:"lastBuildDate,omitempty"`
+	Items         []*rssItemXML `xml:"item"`
+}
+
+type rssItemXML struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	GUID        rssGUIDXML `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Description string     `xml:"description"`
+}
+
+type rssGUIDXML struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Text        string `xml:",chardata"`
+}
+
+func rssFeed(categoryUri string, title string, description string, items []*feedItem) ([]byte, error) {
+	baseUrl := config.GetSiteConfig().Site.BaseUrl
+	feed := &rssFeedXML{Version: "2.0"}
+	feed.Channel.Title = title
+	feed.Channel.Link = absoluteUrl(baseUrl, categoryUri)
+	feed.Channel.Description = description
+	var updated time.Time
+	for _, item := range items {
+		content, err := item.content()
+		if err != nil {
+			return nil, err
+		}
+		link := absoluteUrl(baseUrl, item.uri)
+		feed.Channel.Items = append(feed.Channel.Items, &rssItemXML{
+			Title:       item.title,
+			Link:        link,
+			GUID:        rssGUIDXML{baseUrl != "", link},
+			PubDate:     item.date.Format(time.RFC1123Z),
+			Description: content,
+		})
+		if item.updated.After(updated) {
+			updated = item.updated
+		}
+	}
+	if !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+	return marshalXML(feed)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package note
+
+import (
+	"net/url"
+	"os"
+	"time"
+)
+
+// generator produces files which have no source file but are derived from the note tree, like feeds.
+// Generated files are looked up only if there is no node at the uri.
+type generator interface {
+	// uris returns all uris the generator produces, called with the tree locked for reading
+	uris() []string
+	// generate returns content of uri, or found as false if the uri is not produced by the generator
+	generate(uri string) (content []byte, mimeType string, found bool, err error)
+}
+
+// generatedKeyPrefix distinguishes generated files from pages in the render cache
+const generatedKeyPrefix = "generated:"
+
+func (nr *notesRouter) generate(uri string) ([]byte, string, error) {
+	nr.lock.RLock()
+	contentVersion := nr.contentVersion
+	nr.lock.RUnlock()
+	templateVersion := nr.templateExecutor.Version()
+	key := generatedKeyPrefix + uri
+	if nr.cache != nil {
+		if entry, ok := nr.cache.get(key, time.Time{}, contentVersion, templateVersion); ok {
+			return entry.content, entry.mimeType, nil
+		}
+	}
+
+	for _, g := range nr.generators {
+		content, mimeType, found, err := g.generate(uri)
+		if !found {
+			continue
+		}
+		if err != nil {
+			return nr.templateExecutor.Get500(), "", err
+		}
+		if nr.cache != nil {
+			nr.cache.put(&cacheEntry{key, mimeType, time.Time{}, contentVersion, templateVersion, content})
+		}
+		return content, mimeType, nil
+	}
+	return nr.templateExecutor.Get404(), "", os.ErrNotExist
+}
+
+type generatedUris struct {
+	version        uint64
+	contentVersion uint64
+	uris           map[string]bool
+}
+
+// isGenerated reports whether any generator produces uri, called with the tree locked for reading
+func (nr *notesRouter) isGenerated(uri string) bool {
+	defer nr.generatedLock.Unlock()
+	nr.generatedLock.Lock()
+
+	if g := nr.generated; g == nil || g.version != nr.version || g.contentVersion != nr.contentVersion {
+		uris := make(map[string]bool)
+		for _, g := range nr.generators {
+			for _, uri := range g.uris() {
+				uris[uri] = true
+			}
+		}
+		nr.generated = &generatedUris{nr.version, nr.contentVersion, uris}
+	}
+	return nr.generated.uris[uri]
+}
+
+// absoluteUrl prefixes uri with base url of the site
+func absoluteUrl(baseUrl string, uri string) string {
+	return baseUrl + (&url.URL{Path: uri}).EscapedPath()
+}
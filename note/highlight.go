@@ -0,0 +1,21 @@
+package note
+
+import "github.com/Streamlet/NoteIsSite/note/translator"
+
+// highlightGenerator generates the stylesheet of highlighted code blocks, which have css classes instead of inline
+// styles by "highlight_classes" option in site config
+type highlightGenerator struct{}
+
+const highlightCSSMimeType = "text/css; charset=utf-8"
+
+func (g highlightGenerator) uris() []string {
+	return []string{translator.HighlightCSSUri}
+}
+
+func (g highlightGenerator) generate(uri string) ([]byte, string, bool, error) {
+	if uri != translator.HighlightCSSUri {
+		return nil, "", false, nil
+	}
+	content, err := translator.HighlightCSS()
+	return content, highlightCSSMimeType, true, err
+}
@@ -0,0 +1,161 @@
+package note
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/note/translator"
+)
+
+// indexer keeps data derived from content of notes up to date, translating notes which are new or modified since
+// last sync. The full-text search index and links between notes share the pass.
+type indexer struct {
+	syncLock  sync.Mutex // serializes syncs, which translate notes out of the lock of the tree
+	modTimes  map[string]time.Time
+	noteLinks map[string]*noteLinks
+	version   uint64       // of the tree which links are resolved in
+	search    *searchIndex // nil if search is disabled
+	links     *linkIndex
+}
+
+// noteLinks keeps links of a note, with those to notes and files as written, which are resolved again once the tree
+// changes, as the notes they name may be added, renamed or retitled
+type noteLinks struct {
+	links []string
+	refs  []translator.LinkRef
+}
+
+func newIndexer(search bool) *indexer {
+	ix := new(indexer)
+	ix.modTimes = make(map[string]time.Time)
+	ix.noteLinks = make(map[string]*noteLinks)
+	if search {
+		ix.search = newSearchIndex()
+	}
+	ix.links = newLinkIndex()
+	return ix
+}
+
+// index syncs the indexer with notes in the tree
+func (nr *notesRouter) index() {
+	ix := nr.indexer
+	defer ix.syncLock.Unlock()
+	ix.syncLock.Lock()
+
+	current, version := nr.notes()
+	resolve := version != ix.version
+	linksChanged := false
+	for path, modTime := range current {
+		if indexed, ok := ix.modTimes[path]; ok && indexed.Equal(modTime) {
+			if resolve && ix.links.set(path, nr.resolveLinks(path, ix.noteLinks[path])) {
+				linksChanged = true
+			}
+			continue
+		}
+		doc, err := translator.New(path).Translate()
+		if err != nil {
+			log.Println("failed to index", path, err.Error())
+			continue
+		}
+		ix.modTimes[path] = modTime
+		if ix.search != nil {
+			text := doc.PlainText
+			if doc.Meta.Title != "" {
+				text = doc.Meta.Title + "\n" + text
+			}
+			ix.search.add(path, text)
+		}
+		ix.noteLinks[path] = newNoteLinks(doc)
+		if ix.links.set(path, nr.resolveLinks(path, ix.noteLinks[path])) {
+			linksChanged = true
+		}
+	}
+	for path := range ix.modTimes {
+		if _, ok := current[path]; ok {
+			continue
+		}
+		delete(ix.modTimes, path)
+		delete(ix.noteLinks, path)
+		if ix.search != nil {
+			ix.search.remove(path)
+		}
+		if ix.links.remove(path) {
+			linksChanged = true
+		}
+	}
+
+	ix.version = version
+	// backlinks are part of pages
+	if linksChanged {
+		nr.lock.Lock()
+		nr.version++
+		if nr.version == version+1 {
+			// no other change of the tree since the links are resolved
+			ix.version = nr.version
+		}
+		nr.lock.Unlock()
+	}
+}
+
+// newNoteLinks separates links written as names or paths of notes and files from the others in doc
+func newNoteLinks(doc *translator.Document) *noteLinks {
+	nl := &noteLinks{refs: make([]translator.LinkRef, 0)}
+	unmatched := make(map[string]int)
+	for _, link := range doc.Links {
+		unmatched[link]++
+	}
+	// images are resolved as well, which are not links
+	matched := make(map[string]int)
+	for _, ref := range doc.LinkRefs {
+		if unmatched[ref.Link] > 0 {
+			unmatched[ref.Link]--
+			matched[ref.Link]++
+			nl.refs = append(nl.refs, ref)
+		}
+	}
+	for _, link := range doc.Links {
+		if matched[link] > 0 {
+			matched[link]--
+			continue
+		}
+		nl.links = append(nl.links, link)
+	}
+	return nl
+}
+
+// resolveLinks returns uris in the site linked by the note at path, resolving its links to notes and files in the
+// tree as it is now
+func (nr *notesRouter) resolveLinks(path string, nl *noteLinks) []string {
+	if nl == nil {
+		return nil
+	}
+	links := append([]string(nil), nl.links...)
+	for _, ref := range nl.refs {
+		var uri string
+		var ok bool
+		if ref.Wiki {
+			uri, ok = nr.ResolveWikiLink(path, ref.Target)
+		} else {
+			uri, ok = nr.ResolveLink(path, ref.Target)
+		}
+		if ok {
+			links = append(links, uri)
+		}
+	}
+	return nr.linkTargets(path, links)
+}
+
+// notes returns modification time of all note files in the tree, and version of the tree
+func (nr *notesRouter) notes() (map[string]time.Time, uint64) {
+	defer nr.lock.RUnlock()
+	nr.lock.RLock()
+
+	notes := make(map[string]time.Time)
+	for path, n := range nr.pathNodeMap {
+		if n.isNote && !n.isDir {
+			notes[path] = n.modTime
+		}
+	}
+	return notes, nr.version
+}
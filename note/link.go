@@ -0,0 +1,194 @@
+package note
+
+import (
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Streamlet/NoteIsSite/template"
+)
+
+// linkIndex keeps links from notes, by absolute paths of the notes and uris they link to.
+// Links by names or paths of notes are resolved into uris again by the indexer whenever the tree changes.
+type linkIndex struct {
+	lock    sync.RWMutex
+	targets map[string][]string        // source to sorted target uris
+	sources map[string]map[string]bool // target uri to sources
+}
+
+func newLinkIndex() *linkIndex {
+	li := new(linkIndex)
+	li.targets = make(map[string][]string)
+	li.sources = make(map[string]map[string]bool)
+	return li
+}
+
+// set replaces targets linked from source, returning whether they have changed
+func (li *linkIndex) set(source string, targets []string) bool {
+	defer li.lock.Unlock()
+	li.lock.Lock()
+
+	if reflect.DeepEqual(li.targets[source], targets) {
+		return false
+	}
+	li.removeLocked(source)
+	li.targets[source] = targets
+	for _, target := range targets {
+		if li.sources[target] == nil {
+			li.sources[target] = make(map[string]bool)
+		}
+		li.sources[target][source] = true
+	}
+	return true
+}
+
+// remove drops links from source, returning whether there were any
+func (li *linkIndex) remove(source string) bool {
+	defer li.lock.Unlock()
+	li.lock.Lock()
+
+	return li.removeLocked(source)
+}
+
+func (li *linkIndex) removeLocked(source string) bool {
+	targets, ok := li.targets[source]
+	delete(li.targets, source)
+	for _, target := range targets {
+		delete(li.sources[target], source)
+		if len(li.sources[target]) == 0 {
+			delete(li.sources, target)
+		}
+	}
+	return ok && len(targets) > 0
+}
+
+// backlinks returns notes linking to target uri, sorted
+func (li *linkIndex) backlinks(target string) []string {
+	defer li.lock.RUnlock()
+	li.lock.RLock()
+
+	sources := make([]string, 0, len(li.sources[target]))
+	for source := range li.sources[target] {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// linkTargets returns uris in the site linked by links of the note at path, sorted
+func (nr *notesRouter) linkTargets(path string, links []string) []string {
+	nr.lock.RLock()
+	from, ok := nr.pathNodeMap[path]
+	var base *url.URL
+	if ok {
+		base = &url.URL{Path: from.absoluteUri}
+	}
+	nr.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			continue
+		}
+		if target := strings.ToLower(base.ResolveReference(u).Path); target != base.Path {
+			found[target] = true
+		}
+	}
+	var targets []string
+	for target := range found {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// backlinks returns notes linking to note n, called with the tree locked for reading
+func (nr *notesRouter) backlinks(n *node, preview bool) []*template.BasicItem {
+	items := make([]*template.BasicItem, 0)
+	for _, source := range nr.indexer.links.backlinks(n.absoluteUri) {
+		if s, ok := nr.pathNodeMap[source]; ok && s != n && (!s.unpublished || preview) {
+			items = append(items, &template.BasicItem{Uri: s.absoluteUri, Name: s.name, Meta: s.meta})
+		}
+	}
+	return items
+}
+
+// ResolveLink finds the file by path relative to note source in the note root, or the page by uri relative to the
+// page of source
+func (nr *notesRouter) ResolveLink(source string, path string) (string, bool) {
+	defer nr.lock.RUnlock()
+	nr.lock.RLock()
+
+	target := filepath.Join(filepath.Dir(source), filepath.FromSlash(path))
+	if n, ok := nr.pathNodeMap[target]; ok && isSubPath(target, nr.noteRoot) && !n.unpublished {
+		// resource and static directories have no pages
+		if !n.isDir || n.isNote {
+			return n.absoluteUri, true
+		}
+	}
+
+	from, ok := nr.pathNodeMap[source]
+	if !ok {
+		// index of the category
+		if from, ok = nr.pathNodeMap[filepath.Dir(source)]; !ok {
+			return "", false
+		}
+	}
+	uri := strings.ToLower((&url.URL{Path: from.absoluteUri}).ResolveReference(&url.URL{Path: path}).Path)
+	if n, ok := nr.uriNodeMap[uri]; ok && !n.unpublished {
+		return n.absoluteUri, true
+	}
+	if nr.isGenerated(uri) {
+		return uri, true
+	}
+	return "", false
+}
+
+// ResolveWikiLink finds the note or category by file path relative to source, or to the note root if starting with
+// "/", or by name, title or the last part of uri, in the same category first
+func (nr *notesRouter) ResolveWikiLink(source string, target string) (string, bool) {
+	defer nr.lock.RUnlock()
+	nr.lock.RLock()
+
+	dir := filepath.Dir(source)
+	if strings.HasPrefix(target, "/") {
+		dir = nr.noteRoot
+	}
+	if n, ok := nr.pathNodeMap[filepath.Join(dir, filepath.FromSlash(target))]; ok && n.isNote && !n.unpublished {
+		return n.absoluteUri, true
+	}
+
+	name := strings.ToLower(target)
+	matches := func(n *node) bool {
+		if !n.isNote || n.unpublished || n.parent == nil {
+			return false
+		}
+		segment := strings.TrimSuffix(n.absoluteUri, "/")
+		segment = segment[strings.LastIndex(segment, "/")+1:]
+		return strings.ToLower(n.name) == name || strings.ToLower(n.title()) == name || segment == name
+	}
+	if parent, ok := nr.pathNodeMap[dir]; ok {
+		for _, n := range parent.subItems {
+			if matches(n) {
+				return n.absoluteUri, true
+			}
+		}
+	}
+	var found *node
+	for path, n := range nr.pathNodeMap {
+		if matches(n) && (found == nil || path < found.absolutePath) {
+			found = n
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return found.absoluteUri, true
+}
@@ -4,25 +4,37 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/Streamlet/NoteIsSite/util"
 	"github.com/fsnotify/fsnotify"
 )
 
+type changeType int
+
+const (
+	fileCreated changeType = iota
+	fileRemoved
+	fileChanged
+)
+
 type watcherHandler interface {
-	FileCreated(path string)
-	FileRemoved(path string)
-	FileChanged(path string)
+	// FilesChanged is called with all changes collected during a quiet period, one change per path.
+	FilesChanged(changes map[string]changeType)
 }
 
+// while events keep coming, the change set is delayed no longer than maxQuietPeriods quiet periods
+const maxQuietPeriods = 10
+
 type watcher struct {
-	inner   *fsnotify.Watcher
-	dirs    map[string]bool
-	closing chan bool
-	closed  chan bool
+	inner       *fsnotify.Watcher
+	dirs        map[string]bool
+	quietPeriod time.Duration
+	closing     chan bool
+	closed      chan bool
 }
 
-func newWatcher() (*watcher, error) {
+func newWatcher(quietPeriod time.Duration) (*watcher, error) {
 	innerWatcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -30,6 +42,7 @@ func newWatcher() (*watcher, error) {
 	w := new(watcher)
 	w.inner = innerWatcher
 	w.dirs = make(map[string]bool)
+	w.quietPeriod = quietPeriod
 	w.closing = make(chan bool, 1)
 	w.closed = make(chan bool, 1)
 	return w, nil
@@ -61,31 +74,50 @@ func (w watcher) addDirs(dir string) error {
 func (w watcher) watch(handler watcherHandler) {
 	util.Assert(handler != nil, "handler MUST NOT be nil")
 	go func() {
+		pending := make(map[string]fsnotify.Op)
+		var deadline time.Time
+		timer := time.NewTimer(w.quietPeriod)
+		timer.Stop()
 		for {
 			select {
 			case ev := <-w.inner.Events:
+				if ev.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename|fsnotify.Write) == 0 {
+					continue
+				}
 				if ev.Op&fsnotify.Create != 0 {
-					if f, err := os.Stat(ev.Name); err == nil {
-						if f.IsDir() {
-							_ = w.addDirs(ev.Name)
-						}
-						go handler.FileCreated(ev.Name)
+					// sub directories must be watched at once, or events in them would be lost
+					if f, err := os.Stat(ev.Name); err == nil && f.IsDir() {
+						_ = w.addDirs(ev.Name)
 					}
 				}
 				if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
 					_ = w.inner.Remove(ev.Name)
-					go handler.FileRemoved(ev.Name)
 				}
-				if ev.Op&fsnotify.Write != 0 {
-					if f, err := os.Stat(ev.Name); err == nil {
-						if !f.IsDir() {
-							go handler.FileChanged(ev.Name)
-						}
+				now := time.Now()
+				if len(pending) == 0 {
+					deadline = now.Add(w.quietPeriod * maxQuietPeriods)
+				}
+				pending[ev.Name] |= ev.Op
+				delay := w.quietPeriod
+				if now.Add(delay).After(deadline) {
+					delay = deadline.Sub(now)
+				}
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
 					}
 				}
+				timer.Reset(delay)
+			case <-timer.C:
+				if len(pending) > 0 {
+					handler.FilesChanged(collapseEvents(pending))
+					pending = make(map[string]fsnotify.Op)
+				}
 			case err := <-w.inner.Errors:
 				log.Println(err.Error())
 			case <-w.closing:
+				timer.Stop()
 				w.closed <- true
 				return
 			}
@@ -93,6 +125,21 @@ func (w watcher) watch(handler watcherHandler) {
 	}()
 }
 
+// collapseEvents turns all operations seen on a path into a single change, according to its current state
+func collapseEvents(pending map[string]fsnotify.Op) map[string]changeType {
+	changes := make(map[string]changeType, len(pending))
+	for path, op := range pending {
+		if _, err := os.Stat(path); err != nil {
+			changes[path] = fileRemoved
+		} else if op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+			changes[path] = fileCreated
+		} else {
+			changes[path] = fileChanged
+		}
+	}
+	return changes
+}
+
 func (w watcher) close() error {
 	w.closing <- true
 	<-w.closed
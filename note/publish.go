@@ -0,0 +1,73 @@
+package note
+
+import (
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/note/translator"
+)
+
+// isPublished reports whether a note is public at time now according to its front matter
+func isPublished(meta *translator.Metadata, now time.Time) bool {
+	if meta == nil {
+		return true
+	}
+	if meta.Draft {
+		return false
+	}
+	if meta.PublishDate != nil && now.Before(*meta.PublishDate) {
+		return false
+	}
+	if meta.ExpiryDate != nil && !now.Before(*meta.ExpiryDate) {
+		return false
+	}
+	return true
+}
+
+// schedule arranges to update publishing states of notes at the nearest publish date or expiry date in the future,
+// so notes are published and unpublished in time without any file change. Called with the tree locked for writing.
+func (nr *notesRouter) schedule() {
+	if nr.scheduler != nil {
+		nr.scheduler.Stop()
+		nr.scheduler = nil
+	}
+	now := time.Now()
+	var next time.Time
+	for _, n := range nr.pathNodeMap {
+		if !n.isNote || n.isDir || n.meta == nil || n.meta.Draft {
+			continue
+		}
+		for _, t := range []*time.Time{n.meta.PublishDate, n.meta.ExpiryDate} {
+			if t != nil && t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = *t
+			}
+		}
+	}
+	if !next.IsZero() {
+		nr.scheduler = time.AfterFunc(next.Sub(now), nr.publish)
+	}
+}
+
+// publish updates publishing states of all notes
+func (nr *notesRouter) publish() {
+	defer nr.lock.Unlock()
+	nr.lock.Lock()
+	defer nr.schedule()
+
+	now := time.Now()
+	changed := false
+	for _, n := range nr.pathNodeMap {
+		if !n.isNote || n.isDir {
+			continue
+		}
+		if unpublished := !isPublished(n.meta, now); unpublished != n.unpublished {
+			n.unpublished = unpublished
+			changed = true
+		}
+	}
+	if changed {
+		nr.version++
+		nr.contentVersion++
+		// links to notes published or unpublished are resolved again
+		go nr.index()
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Streamlet/NoteIsSite/config"
 	"github.com/Streamlet/NoteIsSite/note/translator"
@@ -15,17 +17,48 @@ import (
 	"github.com/Streamlet/NoteIsSite/util"
 )
 
+// uris of Search and Status when enabled, which are served only if no note or category has them
+const (
+	SearchUri     = "/search"
+	SearchJSONUri = "/search.json"
+	StatusUri     = "/status.json"
+)
+
 type Router interface {
-	Route(uri string) (content []byte, mimeType string, err error)
+	// preview shows unpublished notes to authors, bypassing the cache
+	Route(uri string, preview bool) (content []byte, mimeType string, err error)
+	// Search renders results of full-text search for query by the search template, or in json if asJSON is true
+	Search(query string, asJSON bool) (content []byte, mimeType string, err error)
+	// Export renders the whole site into outputDir as static files
+	Export(outputDir string) error
+	// Check renders the whole site and reports broken links and other problems in notes and configs
+	Check() *CheckReport
+	// Status reports problems found while building the note tree, like conflicting uris, in json
+	Status() (content []byte, mimeType string, err error)
+	// Close stops watching file system changes
+	Close() error
 }
 
 type notesRouter struct {
 	noteRoot     string
 	templateRoot string
 
-	uriNodeMap map[string]*node
-	lock       sync.RWMutex
-	watcher    *watcher
+	uriNodeMap  map[string]*node
+	pathNodeMap map[string]*node
+	version     uint64 // increased on every change of the tree
+	// increased on every change of notes, including their content, which generated files depend on
+	contentVersion uint64
+	lock           sync.RWMutex
+	watcher        *watcher
+	cache          *renderCache
+	generators     []generator
+	indexer        *indexer
+	scheduler      *time.Timer         // updates publishing states of notes at their publish date or expiry date
+	issues         map[string][]*Issue // found while building the tree, by paths of files in question
+	mode           routerMode
+	// uris of generators, cached for versions of the tree as links are resolved against them
+	generated     *generatedUris
+	generatedLock sync.Mutex
 
 	templateExecutor template.Executor
 }
@@ -37,19 +70,60 @@ type node struct {
 	absoluteUri      string
 	name             string
 	parent           *node
+	isDir            bool
+	meta             *translator.Metadata // notes and categories with index only
+	modTime          time.Time            // of files and indexes of categories, when loaded or changed
+	unpublished      bool                 // draft, or out of publish date and expiry date, notes only
 
 	// dir node only
-	subItems []*node
-	index    string
+	subItems           []*node
+	index              string
+	pattern            *regexp.Regexp
+	categoryTemplate   string // applies to this and sub categories
+	contentTemplate    string // applies to notes in this and sub categories
+	excludeFromArchive bool   // notes in this and sub categories are not listed in archive pages
+	sortBy             string
+	sortDescending     bool
+	order              []string // file names of sub items to be placed first
 }
 
+type routerMode int
+
+const (
+	modeServe routerMode = iota // indexes notes in background for search and backlinks from the start
+	modeBuild                   // renders the whole site once by Export, which indexes notes first
+	// renders the whole site once by Check, which needs no index. Conflicting uris are reported instead of failing
+	// under the error policy, for Check to list all of them.
+	modeCheck
+)
+
 func NewRouter(noteRoot string, templateRoot string) (Router, error) {
+	return newRouter(noteRoot, templateRoot, modeServe)
+}
+
+// NewBuildRouter builds a router for Export
+func NewBuildRouter(noteRoot string, templateRoot string) (Router, error) {
+	return newRouter(noteRoot, templateRoot, modeBuild)
+}
+
+// NewCheckRouter builds a router for Check, which does not fail on conflicting uris under the error policy but
+// reports them
+func NewCheckRouter(noteRoot string, templateRoot string) (Router, error) {
+	return newRouter(noteRoot, templateRoot, modeCheck)
+}
+
+func newRouter(noteRoot string, templateRoot string, mode routerMode) (Router, error) {
 	nr := new(notesRouter)
-	nr.noteRoot = noteRoot
-	nr.templateRoot = templateRoot
+	nr.mode = mode
+	nr.noteRoot = filepath.Clean(noteRoot)
+	nr.templateRoot = filepath.Clean(templateRoot)
+
+	if err := translator.LoadConfig(); err != nil {
+		return nil, err
+	}
 
 	var err error
-	nr.templateExecutor, err = template.NewExecutor(templateRoot)
+	nr.templateExecutor, err = template.NewExecutor(nr.templateRoot)
 	if err != nil {
 		return nil, err
 	}
@@ -59,45 +133,248 @@ func NewRouter(noteRoot string, templateRoot string) (Router, error) {
 		return nil, err
 	}
 
+	if config.GetSiteConfig().Feed.Enabled {
+		nr.generators = append(nr.generators, feedGenerator{nr})
+	}
+	if config.GetSiteConfig().Sitemap.Enabled {
+		nr.generators = append(nr.generators, sitemapGenerator{nr})
+	}
+	if config.GetSiteConfig().Template.TaxonomyTemplate != "" {
+		nr.generators = append(nr.generators, taxonomyGenerator{nr})
+	}
+	if config.GetSiteConfig().Template.ArchiveTemplate != "" {
+		nr.generators = append(nr.generators, archiveGenerator{nr})
+	}
+	if config.GetSiteConfig().Robots.Enabled {
+		nr.generators = append(nr.generators, robotsGenerator{})
+	}
+	if config.GetSiteConfig().Markdown.HighlightClasses {
+		nr.generators = append(nr.generators, highlightGenerator{})
+	}
+
+	if cacheSize := config.GetSiteConfig().Note.RenderCacheSize; cacheSize > 0 {
+		nr.cache = newRenderCache(int64(cacheSize) << 20)
+	}
+
+	nr.indexer = newIndexer(config.GetSiteConfig().Search.Enabled)
+	translator.SetLinkResolver(nr)
+	if mode == modeServe {
+		go nr.index()
+	}
+
+	nr.watcher, err = newWatcher(time.Duration(config.GetSiteConfig().Note.WatchQuietPeriod) * time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	if err := nr.watcher.addDirs(nr.templateRoot); err != nil {
+		return nil, err
+	}
+	if err := nr.watcher.addDirs(nr.noteRoot); err != nil {
+		return nil, err
+	}
+	nr.watcher.watch(nr)
+
 	return nr, nil
 }
 
+func (nr *notesRouter) Close() error {
+	nr.lock.Lock()
+	if nr.scheduler != nil {
+		nr.scheduler.Stop()
+	}
+	nr.lock.Unlock()
+	return nr.watcher.close()
+}
+
 func (nr *notesRouter) rebuild() error {
 	defer nr.lock.Unlock()
 	nr.lock.Lock()
+	defer nr.schedule()
 
-	if nr.watcher != nil {
-		_ = nr.watcher.close()
-		nr.watcher = nil
-	}
-	var err error
-	nr.watcher, err = newWatcher()
-	if err != nil {
-		return err
-	}
-
+	nr.version++
+	nr.contentVersion++
 	nr.uriNodeMap = make(map[string]*node)
+	nr.pathNodeMap = make(map[string]*node)
+	nr.issues = make(map[string][]*Issue)
 	for _, dir := range config.GetSiteConfig().Template.StaticDirs {
 		if err := nr.buildTree("/", filepath.Join(nr.templateRoot, dir), false, nil, nil); err != nil {
 			return err
 		}
 	}
-	if err := nr.watcher.addDirs(nr.templateRoot); err != nil {
-		return err
-	}
 	if err := nr.buildTree("/", nr.noteRoot, true, config.GetSiteConfig().Note.NoteFileRegExp, nil); err != nil {
 		return err
 	}
-	if err := nr.watcher.addDirs(nr.noteRoot); err != nil {
-		return err
+	return nr.checkConflicts()
+}
+
+// update patches the subtrees affected by a set of changes, leaving the rest of the tree untouched.
+// A change of a category or resource config file affects the name and pattern of its directory, so the parent
+// directory is rebuilt. Any other creation or removal only affects the directory containing it.
+func (nr *notesRouter) update(changes map[string]changeType) error {
+	defer nr.lock.Unlock()
+	nr.lock.Lock()
+	defer nr.schedule()
+
+	nr.contentVersion++
+	c := config.GetSiteConfig().Note
+	dirs := make(map[string]bool)
+	for path, change := range changes {
+		dir := filepath.Dir(path)
+		if isSubPath(path, nr.noteRoot) {
+			basename := filepath.Base(path)
+			if basename == c.CategoryConfigFile || basename == c.ResourceConfigFile {
+				if dir == nr.noteRoot {
+					nr.version++
+					if root, ok := nr.pathNodeMap[nr.noteRoot]; ok {
+						nr.removeSubTree(root)
+						delete(nr.pathNodeMap, root.absolutePath)
+					}
+					if err := nr.buildTree("/", nr.noteRoot, true, c.NoteFileRegExp, nil); err != nil {
+						return err
+					}
+					return nr.checkConflicts()
+				}
+				dirs[filepath.Dir(dir)] = true
+				continue
+			}
+		}
+		if change != fileChanged {
+			dirs[dir] = true
+			// rebuilding the directory keeps its own node, whose index may be created or removed
+			if n, ok := nr.pathNodeMap[dir]; ok && n.isNote && n.isDir && n.index == filepath.Base(path) {
+				nr.reloadMetadata(path)
+			}
+		} else {
+			nr.reloadMetadata(path)
+		}
 	}
 
-	nr.watcher.watch(nr)
+	rebuilt := make(map[string]bool)
+	for len(dirs) > 0 {
+		if err := nr.rebuildDirs(dirs, rebuilt); err != nil {
+			return err
+		}
+		// files dropped or renamed for conflicting uris take over the uris once the files kept are removed
+		dirs = nr.dirsOfDroppedNodes()
+		for dir := range dirs {
+			if rebuilt[dir] {
+				delete(dirs, dir)
+			}
+		}
+	}
+	return nr.checkConflicts()
+}
 
+// rebuildDirs rebuilds subtrees of dirs, and records them in rebuilt, so that none is rebuilt twice in an update
+func (nr *notesRouter) rebuildDirs(dirs map[string]bool, rebuilt map[string]bool) error {
+	for dir := range dirs {
+		rebuilt[dir] = true
+		// rebuilding a directory also rebuilds all directories in it
+		covered := false
+		for d, p := dir, filepath.Dir(dir); p != d && !covered; d, p = p, filepath.Dir(p) {
+			covered = dirs[p]
+		}
+		if covered {
+			continue
+		}
+		n, ok := nr.pathNodeMap[dir]
+		if !ok || !n.isDir {
+			// not a part of the site, e.g. a directory without category config
+			continue
+		}
+		nr.version++
+		nr.removeSubTree(n)
+		n.subItems = make([]*node, 0)
+		if err := nr.buildTree(n.absoluteUri, n.absolutePath, n.isNote, n.pattern, n); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (nr *notesRouter) Route(uri string) (content []byte, mimeType string, err error) {
+// reloadMetadata updates metadata of the note, and the category if path is its index. Modification time of other
+// files is updated as well, which cached pages are checked against.
+func (nr *notesRouter) reloadMetadata(path string) {
+	if n, ok := nr.pathNodeMap[path]; ok && !n.isNote && !n.isDir {
+		n.modTime = modTimeOf(path)
+		return
+	}
+	var meta *translator.Metadata
+	reload := func(n *node) {
+		if meta == nil {
+			meta = readMetadata(path)
+		}
+		n.modTime = modTimeOf(path)
+		// the order of items may depend on modification time, besides title, date and weight in metadata
+		dependsOnModTime := n.parent != nil && (n.parent.sortBy == config.SortByMtime || n.parent.sortBy == config.SortByDate)
+		if reflect.DeepEqual(meta, n.meta) && !dependsOnModTime {
+			return
+		}
+		nr.version++
+		n.meta = meta
+		if !n.isDir {
+			n.unpublished = !isPublished(meta, time.Now())
+		}
+		if n.parent != nil {
+			sortSubItems(n.parent)
+		}
+	}
+	if n, ok := nr.pathNodeMap[path]; ok && n.isNote && !n.isDir {
+		reload(n)
+	}
+	if n, ok := nr.pathNodeMap[filepath.Dir(path)]; ok && n.isNote && n.index == filepath.Base(path) {
+		reload(n)
+	}
+}
+
+func readMetadata(path string) *translator.Metadata {
+	meta, err := translator.New(path).ReadMetadata()
+	if err != nil {
+		return new(translator.Metadata)
+	}
+	return meta
+}
+
+// modTimeOf returns modification time of path, or zero time if it is inaccessible
+func modTimeOf(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (nr *notesRouter) removeSubTree(n *node) {
+	prefix := n.absolutePath + string(filepath.Separator)
+	for path, c := range nr.pathNodeMap {
+		if strings.HasPrefix(path, prefix) {
+			delete(nr.pathNodeMap, path)
+			if nr.uriNodeMap[c.absoluteUri] == c {
+				delete(nr.uriNodeMap, c.absoluteUri)
+			}
+		}
+	}
+	for path := range nr.issues {
+		if strings.HasPrefix(path, prefix) {
+			delete(nr.issues, path)
+		}
+	}
+}
+
+func (nr *notesRouter) isStatic(path string) bool {
+	for _, dir := range config.GetSiteConfig().Template.StaticDirs {
+		if isSubPath(path, filepath.Join(nr.templateRoot, dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSubPath(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func (nr *notesRouter) Route(uri string, preview bool) (content []byte, mimeType string, err error) {
 	normalizedUri, err := url.PathUnescape(uri)
 	if err != nil {
 		return nil, "", err
@@ -105,18 +382,53 @@ func (nr *notesRouter) Route(uri string) (content []byte, mimeType string, err e
 	normalizedUri = strings.ToLower(normalizedUri)
 	nr.lock.RLock()
 	n, ok := nr.uriNodeMap[normalizedUri]
+	unpublished := ok && n.unpublished
+	treeVersion := nr.version
 	nr.lock.RUnlock()
-	if !ok {
-		return nr.templateExecutor.Get404(), "", os.ErrNotExist
-	}
-	b, err := n.GetContent()
-	if err != nil {
-		return nr.templateExecutor.Get500(), "", err
+	if !ok || (unpublished && !preview) {
+		return nr.generate(normalizedUri)
 	}
+	return nr.render(n, normalizedUri, treeVersion, preview, nil)
+}
+
+// render renders the page of n at uri in tree of treeVersion. doc is the translated source of n if it is known
+// already, or nil to translate it here.
+func (nr *notesRouter) render(n *node, normalizedUri string, treeVersion uint64, preview bool, doc *translator.Document) (content []byte, mimeType string, err error) {
 	mimeType = ""
 	if n.isNote {
 		mimeType = "text/html"
 	}
+
+	cacheable := nr.cache != nil && !preview
+	// kept up to date by changes of files watched, so that cached pages are served without access to the disk
+	nr.lock.RLock()
+	modTime := n.modTime
+	nr.lock.RUnlock()
+	templateVersion := nr.templateExecutor.Version()
+	if cacheable {
+		if entry, ok := nr.cache.get(normalizedUri, modTime, treeVersion, templateVersion); ok {
+			return entry.content, entry.mimeType, nil
+		}
+	}
+
+	var pageData *template.PageData
+	if n.isNote {
+		nr.lock.RLock()
+		pageData = n.toPageData(preview)
+		if !n.isDir {
+			pageData.Backlinks = nr.backlinks(n, preview)
+		}
+		// the node may have been replaced by a newer one, leave it to the next request to cache
+		cacheable = cacheable && nr.version == treeVersion
+		nr.lock.RUnlock()
+	}
+	b, err := n.GetContent(pageData, doc)
+	if err != nil {
+		return nr.templateExecutor.Get500(), "", err
+	}
+	if cacheable {
+		nr.cache.put(&cacheEntry{normalizedUri, mimeType, modTime, treeVersion, templateVersion, b})
+	}
 	return b, mimeType, nil
 }
 
@@ -132,16 +444,29 @@ func (nr *notesRouter) buildTree(baseUri string, dir string, isNote bool, patter
 		parent.templateExecutor = nr.templateExecutor
 		parent.absolutePath = dir
 		parent.absoluteUri = baseUri
-		if conf, err := config.GetCategoryConfig(parent.absolutePath); err == nil && conf != nil {
+		parent.isDir = true
+		conf, err := config.GetCategoryConfig(parent.absolutePath)
+		if isNote {
+			nr.checkConfig(parent.absolutePath, config.GetSiteConfig().Note.CategoryConfigFile, err)
+		}
+		if err == nil && conf != nil {
 			if conf.Index != "" {
 				parent.index = conf.Index
+				parent.meta = readMetadata(filepath.Join(parent.absolutePath, parent.index))
+				parent.modTime = modTimeOf(filepath.Join(parent.absolutePath, parent.index))
 			}
 			if conf.NoteFileRegExp != nil {
 				pattern = conf.NoteFileRegExp
 			}
+			parent.categoryTemplate = conf.CategoryTemplate
+			parent.contentTemplate = conf.ContentTemplate
+			parent.excludeFromArchive = conf.ExcludeFromArchive
+			parent.setSortConfig(conf)
 		}
 		parent.subItems = make([]*node, 0)
-		nr.uriNodeMap[parent.absoluteUri] = parent
+		parent.pattern = pattern
+		nr.register(parent)
+		nr.pathNodeMap[parent.absolutePath] = parent
 	}
 	for _, f := range files {
 		self := new(node)
@@ -152,12 +477,20 @@ func (nr *notesRouter) buildTree(baseUri string, dir string, isNote bool, patter
 		self.parent = parent
 		fi, err := f.Info()
 		if f.IsDir() || (err == nil && (fi.Mode()&os.ModeSymlink) != 0) {
+			self.isDir = true
 			self.subItems = make([]*node, 0)
 			subIsNote := isNote
 			uriName := self.name
 			patternForChildren := pattern
+			self.categoryTemplate = parent.categoryTemplate
+			self.contentTemplate = parent.contentTemplate
+			self.excludeFromArchive = parent.excludeFromArchive
 			if isNote {
-				if conf, err := config.GetCategoryConfig(self.absolutePath); err == nil && conf != nil {
+				categoryConf, categoryErr := config.GetCategoryConfig(self.absolutePath)
+				resourceConf, resourceErr := config.GetResourceConfig(self.absolutePath)
+				nr.checkConfig(self.absolutePath, config.GetSiteConfig().Note.CategoryConfigFile, categoryErr)
+				nr.checkConfig(self.absolutePath, config.GetSiteConfig().Note.ResourceConfigFile, resourceErr)
+				if conf, err := categoryConf, categoryErr; err == nil && conf != nil {
 					subIsNote = true
 					if conf.Name != "" {
 						uriName = conf.Name
@@ -168,22 +501,44 @@ func (nr *notesRouter) buildTree(baseUri string, dir string, isNote bool, patter
 					}
 					if conf.Index != "" {
 						self.index = conf.Index
+						self.meta = readMetadata(filepath.Join(self.absolutePath, self.index))
+						self.modTime = modTimeOf(filepath.Join(self.absolutePath, self.index))
 					}
 					if conf.NoteFileRegExp != nil {
 						patternForChildren = conf.NoteFileRegExp
 					}
-				} else if conf, err := config.GetResourceConfig(self.absolutePath); err == nil && conf != nil {
+					if conf.CategoryTemplate != "" {
+						self.categoryTemplate = conf.CategoryTemplate
+					}
+					if conf.ContentTemplate != "" {
+						self.contentTemplate = conf.ContentTemplate
+					}
+					if conf.ExcludeFromArchive {
+						self.excludeFromArchive = true
+					}
+					self.setSortConfig(conf)
+				} else if conf, err := resourceConf, resourceErr; err == nil && conf != nil {
 					subIsNote = false
 					if conf.Name != "" {
 						self.name = conf.Name
 					}
 				} else {
+					if os.IsNotExist(categoryErr) && os.IsNotExist(resourceErr) && !isHiddenOrConfig(f.Name()) {
+						nr.addIssue(&Issue{Kind: IssueUnmatchedFile, Level: LevelWarning, Path: self.absolutePath,
+							Message: "directory without category or resource config"})
+					}
 					continue
 				}
 			}
+			self.isNote = subIsNote
+			self.pattern = patternForChildren
 			self.absoluteUri = baseUri + strings.ToLower(uriName) + "/"
-			if !(isNote && !subIsNote) {
-				nr.uriNodeMap[self.absoluteUri] = self
+			// only categories have pages, not resource or static directories
+			if subIsNote && !nr.register(self) {
+				continue
+			}
+			nr.pathNodeMap[self.absolutePath] = self
+			if subIsNote {
 				parent.subItems = append(parent.subItems, self)
 			}
 			if err := nr.buildTree(self.absoluteUri, self.absolutePath, subIsNote, patternForChildren, self); err != nil {
@@ -194,6 +549,10 @@ func (nr *notesRouter) buildTree(baseUri string, dir string, isNote bool, patter
 			if isNote {
 				matches := pattern.FindAllStringSubmatch(f.Name(), -1)
 				if matches == nil {
+					if f.Name() != parent.index && !isHiddenOrConfig(f.Name()) {
+						nr.addIssue(&Issue{Kind: IssueUnmatchedFile, Level: LevelWarning, Path: self.absolutePath,
+							Message: "file not matching note file pattern"})
+					}
 					continue
 				}
 				if len(matches) > 0 {
@@ -211,48 +570,74 @@ func (nr *notesRouter) buildTree(baseUri string, dir string, isNote bool, patter
 						self.name = matches[0][2]
 					}
 				}
-				parent.subItems = append(parent.subItems, self)
+				self.meta = readMetadata(self.absolutePath)
+				self.unpublished = !isPublished(self.meta, time.Now())
+			}
+			if err == nil {
+				self.modTime = fi.ModTime()
 			}
 			self.absoluteUri = baseUri + strings.ToLower(uriName)
-			nr.uriNodeMap[self.absoluteUri] = self
+			if !nr.register(self) {
+				continue
+			}
+			nr.pathNodeMap[self.absolutePath] = self
+			if isNote {
+				parent.subItems = append(parent.subItems, self)
+			}
 		}
 	}
+	sortSubItems(parent)
 	return nil
 }
 
-func (nr *notesRouter) FileCreated(path string) {
-	nr.fsNotify(path)
-}
-
-func (nr *notesRouter) FileRemoved(path string) {
-	nr.fsNotify(path)
-}
-
-func (nr *notesRouter) FileChanged(path string) {
-	nr.fsNotify(path)
+func (n *node) setSortConfig(conf *config.CategoryConfig) {
+	n.sortBy = conf.Sort
+	n.sortDescending = conf.SortOrder == config.SortDescending
+	n.order = conf.Order
 }
 
-func (nr *notesRouter) fsNotify(path string) {
-	basename := filepath.Base(path)
-	parentPath := strings.TrimSuffix(path, string(filepath.Separator)+basename)
-	if !strings.HasPrefix(path, filepath.FromSlash(nr.noteRoot)) && parentPath == filepath.FromSlash(nr.templateRoot) {
+func (nr *notesRouter) FilesChanged(changes map[string]changeType) {
+	templateChanged := false
+	noteChanges := make(map[string]changeType)
+	for path, change := range changes {
+		path = filepath.Clean(path)
+		if !isSubPath(path, nr.noteRoot) && isSubPath(path, nr.templateRoot) && !nr.isStatic(path) {
+			// templates and partials
+			templateChanged = true
+		} else {
+			noteChanges[path] = change
+		}
+	}
+	if templateChanged {
 		if err := nr.templateExecutor.Update(nr.templateRoot); err != nil {
+			log.Println("failed to update templates, previous ones are kept:", err.Error())
+		}
+	}
+	if len(noteChanges) > 0 {
+		if err := nr.update(noteChanges); err != nil {
 			log.Println(err.Error())
 		}
-	} else {
-		_ = nr.rebuild()
+		nr.index()
 	}
 }
 
-func (n *node) toTemplateItem(parent *template.BasicItem, find *node) (itemForThis *template.BasicItem, itemForFind *template.BasicItem) {
+// toTemplateItem converts the subtree of n, in which unpublished notes are omitted unless preview is true
+func (n *node) toTemplateItem(parent *template.BasicItem, find *node, preview bool) (itemForThis *template.BasicItem, itemForFind *template.BasicItem) {
 	itemForThis = new(template.BasicItem)
 	itemForThis.Parent = parent
 	itemForThis.Uri = n.absoluteUri
 	itemForThis.Name = n.name
+	itemForThis.Meta = n.meta
+	if n.meta != nil && !n.isDir {
+		itemForThis.Taxonomies = terms(n.meta)
+	}
 	if n.subItems != nil {
 		itemForThis.Children = make([]*template.BasicItem, 0)
 		for _, c := range n.subItems {
-			item, found := c.toTemplateItem(itemForThis, find)
+			if c.unpublished && !preview {
+				continue
+			}
+			item, found := c.toTemplateItem(itemForThis, find, preview)
 			itemForThis.Children = append(itemForThis.Children, item)
 			if found != nil {
 				itemForFind = found
@@ -266,27 +651,41 @@ func (n *node) toTemplateItem(parent *template.BasicItem, find *node) (itemForTh
 	return
 }
 
-func (n *node) GetContent() ([]byte, error) {
+func (n *node) toPageData(preview bool) *template.PageData {
+	root := n
+	for root.parent != nil {
+		root = root.parent
+	}
+	_, item := root.toTemplateItem(nil, n, preview)
+	for p := item; p != nil; p = p.Parent {
+		p.IsAncestor = true
+	}
+	pageData := new(template.PageData)
+	pageData.BasicItem = item
+	return pageData
+}
 
-	var pageData *template.PageData
-	if n.isNote {
-		root := n
-		for root.parent != nil {
-			root = root.parent
-		}
-		_, item := root.toTemplateItem(nil, n)
-		for p := item; p != nil; p = p.Parent {
-			p.IsAncestor = true
-		}
-		pageData = new(template.PageData)
-		pageData.BasicItem = item
+// sourcePath returns the file the content of the node comes from, or empty string if there is none
+func (n *node) sourcePath() string {
+	if !n.isDir {
+		return n.absolutePath
 	}
-	if n.subItems == nil {
+	if n.index != "" {
+		return filepath.Join(n.absolutePath, n.index)
+	}
+	return ""
+}
+
+// GetContent renders the page of n, doc is the translated source of n if known already, or nil
+func (n *node) GetContent(pageData *template.PageData, doc *translator.Document) ([]byte, error) {
+	if !n.isDir {
 		var content []byte
 		var err error
 		if n.isNote {
-			t := translator.New(n.absolutePath)
-			content, err = t.Translate()
+			if doc == nil {
+				t := translator.New(n.absolutePath)
+				doc, err = t.Translate()
+			}
 		} else {
 			content, err = os.ReadFile(n.absolutePath)
 		}
@@ -298,16 +697,24 @@ func (n *node) GetContent() ([]byte, error) {
 			}
 		}
 		if n.isNote {
-			pageData.Content = string(content)
-			return n.templateExecutor.GetContent(*pageData)
+			pageData.SetDocument(doc)
+			templateName := n.parent.contentTemplate
+			if doc.Meta.Layout != "" {
+				templateName = doc.Meta.Layout
+			}
+			return n.templateExecutor.GetContent(*pageData, templateName)
 		} else {
 			return content, nil
 		}
 	} else {
 		util.Assert(n.isNote, "check code")
+		templateName := n.categoryTemplate
 		if n.index != "" {
-			t := translator.New(filepath.Join(n.absolutePath, n.index))
-			content, err := t.Translate()
+			var err error
+			if doc == nil {
+				t := translator.New(filepath.Join(n.absolutePath, n.index))
+				doc, err = t.Translate()
+			}
 			if err != nil {
 				if os.IsNotExist(err) {
 					return n.templateExecutor.Get404(), err
@@ -315,12 +722,15 @@ func (n *node) GetContent() ([]byte, error) {
 					return n.templateExecutor.Get500(), err
 				}
 			}
-			pageData.Content = string(content)
+			pageData.SetDocument(doc)
+			if doc.Meta.Layout != "" {
+				templateName = doc.Meta.Layout
+			}
 		}
 		if n.parent == nil {
 			return n.templateExecutor.GetIndex(*pageData)
 		} else {
-			return n.templateExecutor.GetCategory(*pageData)
+			return n.templateExecutor.GetCategory(*pageData, templateName)
 		}
 
 	}
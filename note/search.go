@@ -0,0 +1,257 @@
+package note
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/Streamlet/NoteIsSite/note/translator"
+	"github.com/Streamlet/NoteIsSite/template"
+)
+
+// searchIndex is an in-memory inverted index over plain text of notes, keyed by their absolute paths.
+// Words are tokens, while chinese, japanese and korean text has no spaces between words, so each character and each
+// pair of adjacent characters are tokens.
+type searchIndex struct {
+	lock     sync.RWMutex
+	docs     map[string]*indexedDoc
+	postings map[string]map[string]int // token to paths and term frequencies
+}
+
+type indexedDoc struct {
+	text   string // plain text for snippets
+	length int    // number of tokens
+}
+
+type searchHit struct {
+	path  string
+	score float64
+}
+
+const snippetLength = 120 // in characters
+
+func newSearchIndex() *searchIndex {
+	si := new(searchIndex)
+	si.docs = make(map[string]*indexedDoc)
+	si.postings = make(map[string]map[string]int)
+	return si
+}
+
+func (si *searchIndex) add(path string, text string) {
+	tokens := tokenize(strings.ToLower(text), false)
+	doc := &indexedDoc{text, len(tokens)}
+
+	defer si.lock.Unlock()
+	si.lock.Lock()
+
+	si.removeLocked(path)
+	si.docs[path] = doc
+	for _, token := range tokens {
+		postings, ok := si.postings[token]
+		if !ok {
+			postings = make(map[string]int)
+			si.postings[token] = postings
+		}
+		postings[path]++
+	}
+}
+
+func (si *searchIndex) remove(path string) {
+	defer si.lock.Unlock()
+	si.lock.Lock()
+
+	si.removeLocked(path)
+}
+
+func (si *searchIndex) removeLocked(path string) {
+	doc, ok := si.docs[path]
+	if !ok {
+		return
+	}
+	delete(si.docs, path)
+	for _, token := range tokenize(strings.ToLower(doc.text), false) {
+		if postings, ok := si.postings[token]; ok {
+			delete(postings, path)
+			if len(postings) == 0 {
+				delete(si.postings, token)
+			}
+		}
+	}
+}
+
+// search returns paths of notes containing all tokens of query, the most relevant first
+func (si *searchIndex) search(query string) []*searchHit {
+	query = strings.ToLower(query)
+	tokens := tokenize(query, true)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	defer si.lock.RUnlock()
+	si.lock.RLock()
+
+	scores := make(map[string]float64)
+	for i, token := range tokens {
+		postings := si.postings[token]
+		idf := math.Log(1 + float64(len(si.docs))/float64(len(postings)+1))
+		next := make(map[string]float64)
+		for path, tf := range postings {
+			if score, ok := scores[path]; ok || i == 0 {
+				next[path] = score + float64(tf)/float64(si.docs[path].length)*idf
+			}
+		}
+		scores = next
+	}
+
+	hits := make([]*searchHit, 0, len(scores))
+	for path, score := range scores {
+		hits = append(hits, &searchHit{path, score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].path < hits[j].path
+	})
+	return hits
+}
+
+// tokenize splits lower cased text into words, and characters and bigrams of chinese, japanese and korean text.
+// Queries are split into bigrams only if possible, as all tokens of a query must match.
+func tokenize(text string, query bool) []string {
+	tokens := make([]string, 0)
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i := range cjk {
+			if !query || len(cjk) == 1 {
+				tokens = append(tokens, string(cjk[i]))
+			}
+			if i+1 < len(cjk) {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range text {
+		switch {
+		case translator.IsCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// snippet cuts text of the note around the first occurrence of query, or of any of its tokens
+func (si *searchIndex) snippet(path string, query string) string {
+	si.lock.RLock()
+	doc, ok := si.docs[path]
+	si.lock.RUnlock()
+	if !ok {
+		return ""
+	}
+	// lower casing keeps the number of characters, so positions in the lower cased text apply to the original one
+	lower := strings.ToLower(doc.text)
+	query = strings.ToLower(strings.TrimSpace(query))
+	i := strings.Index(lower, query)
+	for _, token := range tokenize(query, true) {
+		if i >= 0 {
+			break
+		}
+		i = strings.Index(lower, token)
+	}
+	runes := []rune(doc.text)
+	start := 0
+	if i > 0 {
+		start = len([]rune(lower[:i])) - snippetLength/4
+		if start < 0 {
+			start = 0
+		}
+	}
+	end := start + snippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+	s := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+type searchResultJSON struct {
+	Uri     string  `json:"uri"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+type searchResultsJSON struct {
+	Query   string              `json:"query"`
+	Results []*searchResultJSON `json:"results"`
+}
+
+func (nr *notesRouter) Search(query string, asJSON bool) ([]byte, string, error) {
+	if nr.indexer.search == nil {
+		return nr.templateExecutor.Get404(), "", os.ErrNotExist
+	}
+	hits := nr.indexer.search.search(query)
+
+	nr.lock.RLock()
+	results := make([]*template.SearchResult, 0)
+	for _, hit := range hits {
+		if len(results) >= int(config.GetSiteConfig().Search.MaxResults) {
+			break
+		}
+		n, ok := nr.pathNodeMap[hit.path]
+		if !ok || !n.isNote || n.isDir || n.unpublished {
+			continue
+		}
+		results = append(results, &template.SearchResult{Uri: n.absoluteUri, Name: n.name, Meta: n.meta, Snippet: nr.indexer.search.snippet(hit.path, query), Score: hit.score})
+	}
+	var pageData *template.PageData
+	if root, ok := nr.pathNodeMap[nr.noteRoot]; ok && !asJSON {
+		pageData = root.toPageData(false)
+	}
+	nr.lock.RUnlock()
+
+	if asJSON {
+		data := &searchResultsJSON{query, make([]*searchResultJSON, 0, len(results))}
+		for _, result := range results {
+			data.Results = append(data.Results, &searchResultJSON{result.Uri, result.Title(), result.Snippet, result.Score})
+		}
+		content, err := json.Marshal(data)
+		if err != nil {
+			return nr.templateExecutor.Get500(), "", err
+		}
+		return content, "application/json", nil
+	}
+	if pageData == nil {
+		return nr.templateExecutor.Get404(), "", os.ErrNotExist
+	}
+	content, err := nr.templateExecutor.GetSearch(template.SearchPageData{PageData: *pageData, Query: query, Results: results})
+	return content, "text/html", err
+}
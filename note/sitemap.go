@@ -0,0 +1,175 @@
+package note
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/config"
+)
+
+// sitemapGenerator generates sitemap.xml listing all public notes and categories.
+// Beyond maxSitemapUrls, sitemap.xml becomes a sitemap index referring to sitemap-1.xml, sitemap-2.xml, etc.
+type sitemapGenerator struct {
+	nr *notesRouter
+}
+
+const (
+	sitemapUri         = "/sitemap.xml"
+	sitemapPagePrefix  = "/sitemap-"
+	sitemapPageSuffix  = ".xml"
+	sitemapMimeType    = "application/xml"
+	sitemapNamespace   = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	maxSitemapUrls     = 50000
+	robotsUri          = "/robots.txt"
+	robotsMimeType     = "text/plain; charset=utf-8"
+	sitemapPageUriForm = sitemapPagePrefix + "%d" + sitemapPageSuffix
+)
+
+type sitemapEntry struct {
+	uri     string
+	lastmod time.Time
+}
+
+func (g sitemapGenerator) uris() []string {
+	uris := []string{sitemapUri}
+	for i := 1; i <= sitemapPages(len(g.entries())); i++ {
+		uris = append(uris, fmt.Sprintf(sitemapPageUriForm, i))
+	}
+	return uris
+}
+
+func (g sitemapGenerator) generate(uri string) ([]byte, string, bool, error) {
+	page := 0
+	if uri != sitemapUri {
+		if !strings.HasPrefix(uri, sitemapPagePrefix) || !strings.HasSuffix(uri, sitemapPageSuffix) {
+			return nil, "", false, nil
+		}
+		var err error
+		page, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(uri, sitemapPagePrefix), sitemapPageSuffix))
+		if err != nil || page <= 0 {
+			return nil, "", false, nil
+		}
+	}
+
+	g.nr.lock.RLock()
+	entries := g.entries()
+	g.nr.lock.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].uri < entries[j].uri
+	})
+	pages := sitemapPages(len(entries))
+	if page > pages {
+		return nil, "", false, nil
+	}
+	if page == 0 && pages > 0 {
+		content, err := sitemapIndex(pages)
+		return content, sitemapMimeType, true, err
+	}
+	if page > 0 {
+		end := page * maxSitemapUrls
+		if end > len(entries) {
+			end = len(entries)
+		}
+		entries = entries[(page-1)*maxSitemapUrls : end]
+	}
+	content, err := sitemapUrlSet(entries)
+	return content, sitemapMimeType, true, err
+}
+
+// entries returns all pages to be listed in sitemaps, called with the tree locked for reading
+func (g sitemapGenerator) entries() []*sitemapEntry {
+	entries := make([]*sitemapEntry, 0, len(g.nr.uriNodeMap))
+	for uri, n := range g.nr.uriNodeMap {
+		// resources and static files are not pages
+		if !n.isNote {
+			continue
+		}
+		if n.unpublished {
+			continue
+		}
+		entry := &sitemapEntry{uri: uri, lastmod: n.modTime}
+		if n.meta != nil {
+			if n.meta.Lastmod != nil {
+				entry.lastmod = *n.meta.Lastmod
+			} else if n.meta.Date != nil {
+				entry.lastmod = *n.meta.Date
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// sitemapPages returns number of sitemaps referred by the sitemap index, or 0 if there is no need for an index
+func sitemapPages(urls int) int {
+	if urls <= maxSitemapUrls {
+		return 0
+	}
+	return (urls + maxSitemapUrls - 1) / maxSitemapUrls
+}
+
+type sitemapUrlSetXML struct {
+	XMLName xml.Name         `xml:"urlset"`
+	Xmlns   string           `xml:"xmlns,attr"`
+	Urls    []*sitemapUrlXML `xml:"url"`
+}
+
+type sitemapUrlXML struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndexXML struct {
+	XMLName  xml.Name         `xml:"sitemapindex"`
+	Xmlns    string           `xml:"xmlns,attr"`
+	Sitemaps []*sitemapUrlXML `xml:"sitemap"`
+}
+
+func sitemapUrlSet(entries []*sitemapEntry) ([]byte, error) {
+	baseUrl := config.GetSiteConfig().Site.BaseUrl
+	urlSet := &sitemapUrlSetXML{Xmlns: sitemapNamespace}
+	for _, entry := range entries {
+		u := &sitemapUrlXML{Loc: absoluteUrl(baseUrl, entry.uri)}
+		if !entry.lastmod.IsZero() {
+			u.Lastmod = entry.lastmod.Format(time.RFC3339)
+		}
+		urlSet.Urls = append(urlSet.Urls, u)
+	}
+	return marshalXML(urlSet)
+}
+
+func sitemapIndex(pages int) ([]byte, error) {
+	baseUrl := config.GetSiteConfig().Site.BaseUrl
+	index := &sitemapIndexXML{Xmlns: sitemapNamespace}
+	for i := 1; i <= pages; i++ {
+		index.Sitemaps = append(index.Sitemaps, &sitemapUrlXML{Loc: absoluteUrl(baseUrl, fmt.Sprintf(sitemapPageUriForm, i))})
+	}
+	return marshalXML(index)
+}
+
+// robotsGenerator generates robots.txt with rules in site config, referring to the sitemap if enabled
+type robotsGenerator struct{}
+
+func (g robotsGenerator) uris() []string {
+	return []string{robotsUri}
+}
+
+func (g robotsGenerator) generate(uri string) ([]byte, string, bool, error) {
+	if uri != robotsUri {
+		return nil, "", false, nil
+	}
+	conf := config.GetSiteConfig()
+	content := conf.Robots.Rules
+	if conf.Sitemap.Enabled {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += "Sitemap: " + absoluteUrl(conf.Site.BaseUrl, sitemapUri) + "\n"
+	}
+	return []byte(content), robotsMimeType, true, nil
+}
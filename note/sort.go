@@ -0,0 +1,61 @@
+package note
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Streamlet/NoteIsSite/config"
+)
+
+// sortSubItems orders notes and sub categories of dir node n by its category config. Items in the order list are
+// placed first, and the others are sorted by the sort key, then by file name.
+func sortSubItems(n *node) {
+	position := make(map[string]int, len(n.order))
+	for i, name := range n.order {
+		position[name] = i
+	}
+	less := func(a *node, b *node) bool {
+		switch n.sortBy {
+		case config.SortByName:
+			return strings.ToLower(a.title()) < strings.ToLower(b.title())
+		case config.SortByDate:
+			return a.date().Before(b.date())
+		case config.SortByWeight:
+			return a.weight() < b.weight()
+		case config.SortByMtime:
+			return a.modTime.Before(b.modTime)
+		case config.SortManually:
+			// by file name, so that sort_order applies as well
+			return filepath.Base(a.absolutePath) < filepath.Base(b.absolutePath)
+		}
+		return false
+	}
+	sort.SliceStable(n.subItems, func(i, j int) bool {
+		a, b := n.subItems[i], n.subItems[j]
+		nameA, nameB := filepath.Base(a.absolutePath), filepath.Base(b.absolutePath)
+		posA, okA := position[nameA]
+		posB, okB := position[nameB]
+		if okA || okB {
+			return okA && (!okB || posA < posB)
+		}
+		if n.sortDescending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return nameA < nameB
+	})
+}
+
+// weight returns weight in front matter, of the index for categories
+func (n *node) weight() int {
+	if n.meta == nil {
+		return 0
+	}
+	return n.meta.Weight
+}
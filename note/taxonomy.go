@@ -0,0 +1,142 @@
+package note
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/Streamlet/NoteIsSite/note/translator"
+	"github.com/Streamlet/NoteIsSite/template"
+)
+
+// taxonomyGenerator generates pages of taxonomies in site config by the taxonomy template,
+// /<taxonomy>/ listing all terms and /<taxonomy>/<term>/ listing notes with the term
+type taxonomyGenerator struct {
+	nr *notesRouter
+}
+
+func (g taxonomyGenerator) uris() []string {
+	uris := make([]string, 0)
+	termUris := make(map[string]bool)
+	for _, taxonomy := range config.GetSiteConfig().Taxonomy.Names {
+		uris = append(uris, taxonomyUri(taxonomy))
+	}
+	for _, n := range g.nr.pathNodeMap {
+		if !n.isNote || n.isDir || n.meta == nil || n.unpublished {
+			continue
+		}
+		for _, terms := range terms(n.meta) {
+			for _, term := range terms {
+				termUris[term.Uri] = true
+			}
+		}
+	}
+	for uri := range termUris {
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+func (g taxonomyGenerator) generate(uri string) ([]byte, string, bool, error) {
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+	if !strings.HasSuffix(uri, "/") || len(parts) > 2 {
+		return nil, "", false, nil
+	}
+	taxonomy := ""
+	for _, name := range config.GetSiteConfig().Taxonomy.Names {
+		if taxonomyUri(name) == "/"+parts[0]+"/" {
+			taxonomy = name
+		}
+	}
+	if taxonomy == "" {
+		return nil, "", false, nil
+	}
+
+	g.nr.lock.RLock()
+	root, ok := g.nr.pathNodeMap[g.nr.noteRoot]
+	var pageData *template.PageData
+	if ok {
+		pageData = root.toPageData(false)
+	}
+	g.nr.lock.RUnlock()
+	if pageData == nil {
+		return nil, "", false, nil
+	}
+
+	data := template.TaxonomyPageData{PageData: *pageData, Taxonomy: taxonomy}
+	data.Terms = collectTerms(pageData.BasicItem, taxonomy)
+	if len(parts) == 2 {
+		for _, term := range data.Terms {
+			if term.Uri == uri {
+				data.Term = term
+			}
+		}
+		if data.Term == nil {
+			return nil, "", false, nil
+		}
+	}
+	content, err := g.nr.templateExecutor.GetTaxonomy(data)
+	return content, "text/html", true, err
+}
+
+// collectTerms collects terms of taxonomy from notes in the item tree, sorted by name
+func collectTerms(root *template.BasicItem, taxonomy string) []*template.TaxonomyTerm {
+	found := make(map[string]*template.TaxonomyTerm)
+	var walk func(item *template.BasicItem)
+	walk = func(item *template.BasicItem) {
+		for _, term := range item.Taxonomies[taxonomy] {
+			t, ok := found[term.Uri]
+			if !ok {
+				t = &template.TaxonomyTerm{Term: *term}
+				found[term.Uri] = t
+			}
+			t.Items = append(t.Items, item)
+		}
+		for _, c := range item.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	terms := make([]*template.TaxonomyTerm, 0, len(found))
+	for _, t := range found {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return strings.ToLower(terms[i].Name) < strings.ToLower(terms[j].Name)
+	})
+	return terms
+}
+
+// terms returns terms of all taxonomies in site config from the front matter
+func terms(meta *translator.Metadata) map[string][]*template.Term {
+	result := make(map[string][]*template.Term)
+	for _, taxonomy := range config.GetSiteConfig().Taxonomy.Names {
+		for _, name := range meta.Terms(taxonomy) {
+			if name = strings.TrimSpace(name); name != "" {
+				result[taxonomy] = append(result[taxonomy], &template.Term{Name: name, Uri: termUri(taxonomy, name)})
+			}
+		}
+	}
+	return result
+}
+
+func taxonomyUri(taxonomy string) string {
+	return "/" + uriSegment(taxonomy) + "/"
+}
+
+func termUri(taxonomy string, term string) string {
+	return taxonomyUri(taxonomy) + uriSegment(term) + "/"
+}
+
+// uriSegment makes name a segment of uri, which is lower cased as all uris are. Characters separating or escaping
+// parts of urls are replaced with -, as links to "c#" or "why?" would never reach the page.
+func uriSegment(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', '?', '#', '%':
+			return '-'
+		}
+		return r
+	}, strings.ToLower(name))
+}
@@ -12,6 +12,20 @@ func newDefaultTranslator(path string) *defaultTranslator {
 	return t
 }
 
-func (t defaultTranslator) Translate() ([]byte, error) {
-	return os.ReadFile(t.path)
+// Translate outputs the content as-is, which is supposed to be HTML
+func (t defaultTranslator) Translate() (*Document, error) {
+	content, err := os.ReadFile(t.path)
+	if err != nil {
+		return nil, err
+	}
+	doc := newDocument(content, new(Metadata), "")
+	analyzeHTML(doc, content)
+	return doc, nil
+}
+
+func (t defaultTranslator) ReadMetadata() (*Metadata, error) {
+	if _, err := os.Stat(t.path); err != nil {
+		return nil, err
+	}
+	return new(Metadata), nil
 }
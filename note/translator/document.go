@@ -0,0 +1,200 @@
+package translator
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Document is the result of translating a note
+type Document struct {
+	HTML      []byte
+	Meta      *Metadata
+	TOC       []*Heading // all headings in order of appearance
+	Summary   string     // plain text before summary divider, or of the first paragraph
+	PlainText string
+	WordCount int
+	Links     []string  // destinations of links
+	LinkRefs  []LinkRef // links to notes and files in the site as written, markdown only
+	Assets    []string  // sources of images and other embedded resources
+}
+
+type Heading struct {
+	Level int
+	ID    string
+	Title string
+}
+
+const (
+	summaryDivider = "<!--more-->"
+	summaryLength  = 200 // in characters
+)
+
+func newDocument(htmlContent []byte, meta *Metadata, plainText string) *Document {
+	doc := new(Document)
+	doc.HTML = htmlContent
+	doc.Meta = meta
+	doc.TOC = make([]*Heading, 0)
+	doc.Links = make([]string, 0)
+	doc.Assets = make([]string, 0)
+	doc.PlainText = plainText
+	doc.Summary = summarize(plainText)
+	doc.WordCount = countWords(plainText)
+	return doc
+}
+
+// analyzeMarkdown fills document information other than HTML by walking through the markdown AST
+func analyzeMarkdown(doc *Document, root ast.Node, source []byte) {
+	var text bytes.Buffer
+	dividerEnd, firstParagraphStart, firstParagraphEnd := -1, -1, -1
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock && text.Len() > 0 && !bytes.HasSuffix(text.Bytes(), []byte("\n")) {
+				text.WriteByte('\n')
+			}
+			if n.Kind() == ast.KindParagraph && firstParagraphEnd < 0 && n.Parent() == root {
+				firstParagraphEnd = text.Len()
+			}
+			return ast.WalkContinue, nil
+		}
+		if n.Kind() == ast.KindParagraph && firstParagraphStart < 0 && n.Parent() == root {
+			firstParagraphStart = text.Len()
+		}
+		switch node := n.(type) {
+		case *ast.Heading:
+			heading := &Heading{Level: node.Level, Title: string(node.Text(source))}
+			if id, ok := node.AttributeString("id"); ok {
+				if b, ok := id.([]byte); ok {
+					heading.ID = string(b)
+				}
+			}
+			doc.TOC = append(doc.TOC, heading)
+		case *ast.Link:
+			doc.Links = append(doc.Links, string(node.Destination))
+		case *ast.AutoLink:
+			doc.Links = append(doc.Links, string(node.URL(source)))
+		case *ast.Image:
+			doc.Assets = append(doc.Assets, string(node.Destination))
+		case *ast.Text:
+			text.Write(node.Segment.Value(source))
+			if node.HardLineBreak() {
+				text.WriteByte('\n')
+			} else if node.SoftLineBreak() {
+				text.WriteByte(' ')
+			}
+		case *ast.String:
+			text.Write(node.Value)
+		case *mathInline, *mathBlock:
+			text.Write(formula(node, source))
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				segment := lines.At(i)
+				text.Write(segment.Value(source))
+			}
+		case *ast.HTMLBlock:
+			lines := node.Lines()
+			if lines.Len() > 0 {
+				segment := lines.At(0)
+				if bytes.HasPrefix(bytes.TrimSpace(segment.Value(source)), []byte(summaryDivider)) && dividerEnd < 0 {
+					dividerEnd = text.Len()
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	doc.PlainText = strings.TrimSpace(text.String())
+	if dividerEnd >= 0 {
+		doc.Summary = strings.TrimSpace(text.String()[:dividerEnd])
+	} else if firstParagraphEnd >= 0 {
+		doc.Summary = summarize(text.String()[firstParagraphStart:firstParagraphEnd])
+	} else {
+		doc.Summary = summarize(doc.PlainText)
+	}
+	doc.WordCount = countWords(doc.PlainText)
+}
+
+// summarize cuts text to summaryLength characters at most
+func summarize(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= summaryLength {
+		return string(runes)
+	}
+	return string(runes[:summaryLength]) + "…"
+}
+
+// countWords counts each CJK character as a word, as well as each sequence of other letters or digits
+func countWords(text string) int {
+	count := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case IsCJK(r):
+			count++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				count++
+			}
+			inWord = true
+		default:
+			inWord = false
+		}
+	}
+	return count
+}
+
+// IsCJK reports whether r is a chinese, japanese or korean character, which is a word by itself
+func IsCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+var (
+	htmlTagRegExp     = regexp.MustCompile(`(?is)<script.*?</script>|<style.*?</style>|<[^>]*>`)
+	htmlLinkRegExp    = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*["']([^"']*)["']`)
+	htmlAssetRegExp   = regexp.MustCompile(`(?i)<(?:img|script|iframe|video|audio|source|embed)\s[^>]*?src\s*=\s*["']([^"']*)["']`)
+	htmlHeadingRegExp = regexp.MustCompile(`(?is)<h([1-6])(\s[^>]*)?>(.*?)</h[1-6]>`)
+	htmlIdRegExp      = regexp.MustCompile(`(?i)\sid\s*=\s*["']([^"']*)["']`)
+	whitespacesRegExp = regexp.MustCompile(`\s+`)
+	htmlCommentRegExp = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// analyzeHTML fills document information other than HTML by scanning the HTML content
+func analyzeHTML(doc *Document, content []byte) {
+	for _, m := range htmlHeadingRegExp.FindAllSubmatch(content, -1) {
+		heading := &Heading{Level: int(m[1][0] - '0'), Title: htmlToText(m[3])}
+		if id := htmlIdRegExp.FindSubmatch(m[2]); id != nil {
+			heading.ID = string(id[1])
+		}
+		doc.TOC = append(doc.TOC, heading)
+	}
+	for _, m := range htmlLinkRegExp.FindAllSubmatch(content, -1) {
+		doc.Links = append(doc.Links, html.UnescapeString(string(m[1])))
+	}
+	for _, m := range htmlAssetRegExp.FindAllSubmatch(content, -1) {
+		doc.Assets = append(doc.Assets, html.UnescapeString(string(m[1])))
+	}
+	doc.PlainText = htmlToText(content)
+	if i := bytes.Index(content, []byte(summaryDivider)); i >= 0 {
+		doc.Summary = htmlToText(content[:i])
+	} else {
+		doc.Summary = summarize(doc.PlainText)
+	}
+	doc.WordCount = countWords(doc.PlainText)
+}
+
+func htmlToText(content []byte) string {
+	content = htmlCommentRegExp.ReplaceAll(content, nil)
+	content = htmlTagRegExp.ReplaceAll(content, []byte(" "))
+	text := html.UnescapeString(string(content))
+	return strings.TrimSpace(whitespacesRegExp.ReplaceAllString(text, " "))
+}
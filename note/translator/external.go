@@ -0,0 +1,93 @@
+package translator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/config"
+)
+
+// externalTranslator pipes the file through a local program, e.g. pandoc, whose output is supposed to be HTML
+type externalTranslator struct {
+	defaultTranslator
+	conf *config.ExternalTranslatorConfig
+}
+
+const filePlaceholder = "{file}"
+
+func newExternalTranslator(path string, conf *config.ExternalTranslatorConfig) *externalTranslator {
+	t := new(externalTranslator)
+	t.path = path
+	t.conf = conf
+	return t
+}
+
+func (t externalTranslator) Translate() (*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(t.conf.Timeout)*time.Second)
+	defer cancel()
+
+	// the file is passed by argument if there is a placeholder, otherwise by stdin
+	args := make([]string, 0, len(t.conf.Command)-1)
+	byStdin := true
+	for _, arg := range t.conf.Command[1:] {
+		if strings.Contains(arg, filePlaceholder) {
+			arg = strings.ReplaceAll(arg, filePlaceholder, t.path)
+			byStdin = false
+		}
+		args = append(args, arg)
+	}
+	cmd := exec.CommandContext(ctx, t.conf.Command[0], args...)
+	if byStdin {
+		f, err := os.Open(t.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+	stdout := &limitedBuffer{limit: int(t.conf.MaxOutputSize) << 20, cancel: cancel}
+	stderr := &limitedBuffer{limit: 4 << 10}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if stdout.exceeded {
+		return nil, fmt.Errorf("output of %s exceeds %d MB when translating %s", t.conf.Name, t.conf.MaxOutputSize, t.path)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %d seconds when translating %s", t.conf.Name, t.conf.Timeout, t.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed when translating %s: %s %s", t.conf.Name, t.path, err.Error(), stderr.buffer.String())
+	}
+
+	content := stdout.buffer.Bytes()
+	doc := newDocument(content, new(Metadata), "")
+	analyzeHTML(doc, content)
+	return doc, nil
+}
+
+// limitedBuffer fails writing beyond limit, and cancels the command if cancel is set.
+// bytes.Buffer is not embedded, or its ReadFrom would be used by exec to bypass the limit.
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buffer.Len()+len(p) > b.limit {
+		b.exceeded = true
+		if b.cancel != nil {
+			b.cancel()
+		}
+		return 0, fmt.Errorf("output exceeds %d bytes", b.limit)
+	}
+	return b.buffer.Write(p)
+}
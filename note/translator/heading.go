@@ -0,0 +1,99 @@
+package translator
+
+import (
+	"strconv"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// headingIDs generates ids of headings from their text, keeping letters of any language, e.g. "中文 标题" as
+// "中文-标题", so that links to headings stay the same as long as the text is not changed.
+// Duplicate ids get "-1", "-2", etc. appended in order of appearance.
+type headingIDs struct {
+	used map[string]bool
+}
+
+const defaultHeadingID = "heading"
+
+func newHeadingIDs() *headingIDs {
+	return &headingIDs{make(map[string]bool)}
+}
+
+func (ids *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	id := make([]rune, 0, len(value))
+	separated := false
+	for _, r := range string(value) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if separated && len(id) > 0 {
+				id = append(id, '-')
+			}
+			separated = false
+			id = append(id, unicode.ToLower(r))
+		default:
+			// spaces, hyphens and punctuations
+			separated = true
+		}
+	}
+	result := string(id)
+	if result == "" {
+		result = defaultHeadingID
+	}
+	if ids.used[result] {
+		for i := 1; ; i++ {
+			if candidate := result + "-" + strconv.Itoa(i); !ids.used[candidate] {
+				result = candidate
+				break
+			}
+		}
+	}
+	ids.used[result] = true
+	return []byte(result)
+}
+
+func (ids *headingIDs) Put(value []byte) {
+	ids.used[string(value)] = true
+}
+
+// headingPermalinks is a goldmark extension rendering headings with anchors linking to themselves
+type headingPermalinks struct{}
+
+func (e headingPermalinks) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(headingRenderer{}, 100)))
+}
+
+type headingRenderer struct{}
+
+func (r headingRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHeading, r.renderHeading)
+}
+
+// renderHeading renders as goldmark does, with the anchor at the end
+func (r headingRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Heading)
+	if entering {
+		_, _ = w.WriteString("<h")
+		_ = w.WriteByte("0123456"[n.Level])
+		if n.Attributes() != nil {
+			html.RenderAttributes(w, node, html.HeadingAttributeFilter)
+		}
+		_ = w.WriteByte('>')
+	} else {
+		if id, ok := n.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				_, _ = w.WriteString(`<a class="anchor" href="#`)
+				_, _ = w.Write(util.EscapeHTML(util.URLEscape(b, false)))
+				_, _ = w.WriteString(`" aria-hidden="true">#</a>`)
+			}
+		}
+		_, _ = w.WriteString("</h")
+		_ = w.WriteByte("0123456"[n.Level])
+		_, _ = w.WriteString(">\n")
+	}
+	return ast.WalkContinue, nil
+}
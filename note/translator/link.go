@@ -0,0 +1,120 @@
+package translator
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// LinkResolver maps links in notes to uris of the site
+type LinkResolver interface {
+	// ResolveWikiLink returns uri of the note named by target, which is a name or a file path, linked from note source
+	ResolveWikiLink(source string, target string) (uri string, ok bool)
+	// ResolveLink returns uri of the file at relative path linked from note source, or of the page if path is a
+	// relative uri already
+	ResolveLink(source string, path string) (uri string, ok bool)
+}
+
+var linkResolver LinkResolver
+
+// SetLinkResolver sets the resolver of wiki links in all notes
+func SetLinkResolver(resolver LinkResolver) {
+	defer registryLock.Unlock()
+	registryLock.Lock()
+
+	linkResolver = resolver
+}
+
+func getLinkResolver() LinkResolver {
+	defer registryLock.RUnlock()
+	registryLock.RLock()
+
+	return linkResolver
+}
+
+// LinkRef is a link to a note or file as written in the note, which is resolved by LinkResolver into Link
+type LinkRef struct {
+	Wiki   bool   // [[target]], or a link by relative path otherwise
+	Target string // name or path of the note or file, without anchor
+	Link   string // destination of the link in the page, which may be stale once notes are added or renamed
+}
+
+var (
+	sourcePathKey   = parser.NewContextKey()
+	linkResolverKey = parser.NewContextKey()
+	linkRefsKey     = parser.NewContextKey()
+)
+
+// brokenLinkClass marks links to nothing in the site
+const brokenLinkClass = "broken-link"
+
+// newParserContext carries the note path and the link resolver to the wiki link parser, with ids of headings
+func newParserContext(source string) parser.Context {
+	pc := parser.NewContext(parser.WithIDs(newHeadingIDs()))
+	pc.Set(sourcePathKey, source)
+	pc.Set(linkResolverKey, getLinkResolver())
+	pc.Set(linkRefsKey, new([]LinkRef))
+	return pc
+}
+
+// addLinkRef records a link resolved by the link resolver
+func addLinkRef(pc parser.Context, ref LinkRef) {
+	if refs, ok := pc.Get(linkRefsKey).(*[]LinkRef); ok {
+		*refs = append(*refs, ref)
+	}
+}
+
+// linkRefs returns links resolved by the link resolver while parsing
+func linkRefs(pc parser.Context) []LinkRef {
+	if refs, ok := pc.Get(linkRefsKey).(*[]LinkRef); ok {
+		return *refs
+	}
+	return nil
+}
+
+// relativeLinks rewrites relative links and images in notes to uris of the site, as notes are written with paths of
+// files, while uris are lower cased, renamed by patterns and category configs. Links to nothing in the site are marked.
+type relativeLinks struct{}
+
+func (t relativeLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	resolver, _ := pc.Get(linkResolverKey).(LinkResolver)
+	source, _ := pc.Get(sourcePathKey).(string)
+	if resolver == nil || source == "" {
+		return
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Link:
+			node.Destination = resolveRelativeLink(pc, resolver, source, node, node.Destination)
+		case *ast.Image:
+			node.Destination = resolveRelativeLink(pc, resolver, source, node, node.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func resolveRelativeLink(pc parser.Context, resolver LinkResolver, source string, n ast.Node, destination []byte) []byte {
+	// broken wiki links are marked already
+	if _, ok := n.AttributeString("class"); ok {
+		return destination
+	}
+	u, err := url.Parse(string(destination))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return destination
+	}
+	uri, ok := resolver.ResolveLink(source, u.Path)
+	if !ok {
+		n.SetAttributeString("class", []byte(brokenLinkClass))
+		addLinkRef(pc, LinkRef{Target: u.Path, Link: string(destination)})
+		return destination
+	}
+	resolved := []byte((&url.URL{Path: uri, RawQuery: u.RawQuery, Fragment: u.Fragment}).String())
+	addLinkRef(pc, LinkRef{Target: u.Path, Link: string(resolved)})
+	return resolved
+}
@@ -3,16 +3,22 @@ package translator
 import (
 	"bytes"
 	"encoding/json"
-	"github.com/yuin/goldmark/extension"
 	"os"
 	"regexp"
-	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Streamlet/NoteIsSite/config"
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/go-yaml/yaml"
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
 type markdownTranslator struct {
@@ -25,71 +31,146 @@ func newMarkdownTranslator(path string) *markdownTranslator {
 	return t
 }
 
-func (t markdownTranslator) Translate() ([]byte, error) {
+func (t markdownTranslator) Translate() (*Document, error) {
 	content, err := os.ReadFile(t.path)
 	if err != nil {
 		return nil, err
 	}
 
-	content = parseHugoHeader(content)
+	content, header := parseHugoHeader(content)
+
+	md := newMarkdown()
+	pc := newParserContext(t.path)
+	root := md.Parser().Parse(text.NewReader(content), parser.WithContext(pc))
+	var buffer bytes.Buffer
+	if err := md.Renderer().Render(&buffer, content, root); err != nil {
+		return nil, err
+	}
+
+	doc := newDocument(buffer.Bytes(), newMetadata(header), "")
+	analyzeMarkdown(doc, root, content)
+	doc.LinkRefs = linkRefs(pc)
+	return doc, nil
+}
 
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("vs"),
-				highlighting.WithFormatOptions(
-					chromahtml.WithLineNumbers(true),
-				),
-			)),
+func (t markdownTranslator) ReadMetadata() (*Metadata, error) {
+	content, err := os.ReadFile(t.path)
+	if err != nil {
+		return nil, err
+	}
+	_, header := parseHugoHeader(content)
+	return newMetadata(header), nil
+}
+
+// Markdownify converts markdown text to HTML. The enclosing <p> tag is removed if there is only one paragraph.
+func Markdownify(content []byte) ([]byte, error) {
+	htmlContent, err := convertMarkdown(content)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(htmlContent)
+	if bytes.HasPrefix(trimmed, []byte("<p>")) && bytes.HasSuffix(trimmed, []byte("</p>")) &&
+		bytes.Count(trimmed, []byte("<p>")) == 1 {
+		return trimmed[len("<p>") : len(trimmed)-len("</p>")], nil
+	}
+	return htmlContent, nil
+}
+
+func newMarkdown() goldmark.Markdown {
+	c := config.GetSiteConfig()
+	extensions := []goldmark.Extender{
+		extension.GFM,
+		wikiLinks{},
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(c.Markdown.HighlightStyle),
+			highlighting.WithFormatOptions(highlightOptions()...),
+		),
+	}
+	if c.Markdown.Footnotes {
+		extensions = append(extensions, extension.Footnote)
+	}
+	if c.Markdown.DefinitionLists {
+		extensions = append(extensions, extension.DefinitionList)
+	}
+	if c.Markdown.Typographer {
+		extensions = append(extensions, extension.Typographer)
+	}
+	if c.TOC.Permalinks {
+		extensions = append(extensions, headingPermalinks{})
+	}
+	if c.Markdown.Math != "" {
+		extensions = append(extensions, math{c.Markdown.Math})
+	}
+
+	parserOptions := []parser.Option{
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(relativeLinks{}, 100)),
+	}
+	if c.Markdown.Attributes {
+		parserOptions = append(parserOptions, parser.WithAttribute())
+	}
+	var rendererOptions []renderer.Option
+	if c.Markdown.Unsafe {
+		rendererOptions = append(rendererOptions, html.WithUnsafe())
+	}
+	if c.Markdown.HardWraps {
+		rendererOptions = append(rendererOptions, html.WithHardWraps())
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
 	)
+}
+
+func highlightOptions() []chromahtml.Option {
+	return []chromahtml.Option{
+		chromahtml.WithLineNumbers(config.GetSiteConfig().Markdown.LineNumbers),
+		chromahtml.WithClasses(config.GetSiteConfig().Markdown.HighlightClasses),
+	}
+}
+
+// HighlightCSSUri is where the site serves HighlightCSS
+const HighlightCSSUri = "/highlight.css"
 
+// HighlightCSS returns the stylesheet of code blocks highlighted with css classes, by the style in site config
+func HighlightCSS() ([]byte, error) {
 	var buffer bytes.Buffer
-	if err := md.Convert(content, &buffer); err != nil {
+	style := styles.Get(config.GetSiteConfig().Markdown.HighlightStyle)
+	if err := chromahtml.New(highlightOptions()...).WriteCSS(&buffer, style); err != nil {
 		return nil, err
 	}
-	htmlContent := buffer.Bytes()
-	return htmlContent, nil
+	return buffer.Bytes(), nil
 }
 
-type hugoHeader struct {
-	Title *string    `yaml:"title" toml:"title" json:"title"`
-	Date  *time.Time `yaml:"date" toml:"date" json:"date"`
+func convertMarkdown(content []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := newMarkdown().Convert(content, &buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
 }
 
-func parseHugoHeader(content []byte) []byte {
-	var header *hugoHeader
+func parseHugoHeader(content []byte) ([]byte, map[string]interface{}) {
+	var header map[string]interface{}
 	if matches := regexp.MustCompile("^---\\n((?:.*\\n)*?)---\\n").FindAllSubmatch(content, -1); matches != nil {
 		content = bytes.TrimPrefix(content, matches[0][0])
-		var h hugoHeader
+		var h map[string]interface{}
 		if err := yaml.Unmarshal(matches[0][1], &h); err == nil {
-			header = &h
+			header = h
 		}
 	} else if matches := regexp.MustCompile("^\\+\\+\\+\\n((?:.*\\n)*?)\\+\\+\\+\\n").FindAllSubmatch(content, -1); matches != nil {
 		content = bytes.TrimPrefix(content, matches[0][0])
-		var h hugoHeader
+		var h map[string]interface{}
 		if _, err := toml.Decode(string(matches[0][1]), &h); err == nil {
-			header = &h
+			header = h
 		}
 	} else if matches := regexp.MustCompile("^({(?:.*\\n)*?})\\n").FindAllSubmatch(content, -1); matches != nil {
 		content = bytes.TrimPrefix(content, matches[0][0])
-		var h hugoHeader
+		var h map[string]interface{}
 		if err := json.Unmarshal(matches[0][1], &h); err == nil {
-			header = &h
+			header = h
 		}
 	}
-	if header == nil {
-		return content
-	}
-	prefix := ""
-	if header.Title != nil {
-		prefix += "# " + *header.Title + "\n"
-	}
-	if header.Date != nil {
-		prefix += header.Date.Format("2006-01-02 15:04:05") + "\n"
-	}
-	if prefix != "" {
-		content = append([]byte(prefix+"\n"), content...)
-	}
-	return content
+	return content, header
 }
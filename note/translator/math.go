@@ -0,0 +1,232 @@
+package translator
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathInline is a formula in $...$, or $$...$$ displayed as a block within a paragraph
+type mathInline struct {
+	ast.BaseInline
+	Display bool
+}
+
+var kindMathInline = ast.NewNodeKind("MathInline")
+
+func (n *mathInline) Kind() ast.NodeKind {
+	return kindMathInline
+}
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathBlock is a formula between lines starting and ending with $$, with the formula in its lines
+type mathBlock struct {
+	ast.BaseBlock
+	closed bool // $$formula$$ in a single line
+}
+
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+func (n *mathBlock) Kind() ast.NodeKind {
+	return kindMathBlock
+}
+
+func (n *mathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// formula returns tex source of a math node
+func formula(n ast.Node, source []byte) []byte {
+	var buffer bytes.Buffer
+	if _, ok := n.(*mathBlock); ok {
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buffer.Write(segment.Value(source))
+		}
+		return bytes.TrimSpace(buffer.Bytes())
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			buffer.Write(t.Segment.Value(source))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// math is a goldmark extension for tex formulas, rendered as MathML, or kept for client-side renderers like KaTeX
+// and MathJax in \(...\) and \[...\] by the math option in site config
+type math struct {
+	mode string
+}
+
+func (e math) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 150)),
+		// before code spans, emphasis and links, so that _, * and \ in formulas are kept as they are
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 50)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{e.mode}, 100)))
+}
+
+type mathInlineParser struct{}
+
+func (p mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse follows pandoc: the opening $ is not followed by a space, and the closing $ is not preceded by a space or
+// followed by a digit, so that prices like $5 and $10 stay as they are
+func (p mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delimiter := 1
+	if len(line) > 1 && line[1] == '$' {
+		delimiter = 2
+	}
+	if len(line) <= delimiter || util.IsSpace(line[delimiter]) {
+		return nil
+	}
+	for i := delimiter; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if delimiter == 2 {
+				if i+1 >= len(line) || line[i+1] != '$' {
+					continue
+				}
+			} else if util.IsSpace(line[i-1]) || (i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9') {
+				continue
+			}
+			if i == delimiter {
+				return nil
+			}
+			node := &mathInline{Display: delimiter == 2}
+			node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(segment.Start+delimiter, segment.Start+i)))
+			block.Advance(i + delimiter)
+			return node
+		}
+	}
+	return nil
+}
+
+type mathBlockParser struct{}
+
+var mathBlockDelimiter = []byte("$$")
+
+func (p mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Open accepts a line of nothing but $$, or $$formula$$, otherwise $$ is left to the inline parser, so that
+// "$$a$$ and $$b$$" or "$$x$$ is the energy" stays in its paragraph
+func (p mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathBlockDelimiter) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	rest := util.TrimRightSpace(line[pos+len(mathBlockDelimiter):])
+	if len(rest) > 0 {
+		// $$formula$$ in a single line
+		if len(rest) <= len(mathBlockDelimiter) || !bytes.HasSuffix(rest, mathBlockDelimiter) {
+			return nil, parser.NoChildren
+		}
+		inner := rest[:len(rest)-len(mathBlockDelimiter)]
+		if bytes.Contains(inner, mathBlockDelimiter) {
+			return nil, parser.NoChildren
+		}
+		start := segment.Start + pos + len(mathBlockDelimiter)
+		node.Lines().Append(text.NewSegment(start, start+len(inner)))
+		node.closed = true
+	}
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (p mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, mathBlockDelimiter) {
+		if len(trimmed) > len(mathBlockDelimiter) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+len(trimmed)-len(mathBlockDelimiter)))
+		}
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (p mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+}
+
+func (p mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathRenderer struct {
+	mode string
+}
+
+func (r mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, r.renderMath)
+	reg.Register(kindMathBlock, r.renderMath)
+}
+
+func (r mathRenderer) renderMath(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	tex := string(formula(n, source))
+	display := true
+	if inline, ok := n.(*mathInline); ok {
+		display = inline.Display
+	}
+	_, isBlock := n.(*mathBlock)
+	if r.mode == config.MathMathML {
+		_, _ = w.WriteString(texToMathML(tex, display))
+	} else {
+		// escaped source in delimiters of KaTeX and MathJax, in elements of no markdown
+		element := "span"
+		if isBlock {
+			element = "div"
+		}
+		open, closing := `\(`, `\)`
+		class := "math inline"
+		if display {
+			open, closing = `\[`, `\]`
+			class = "math display"
+		}
+		_, _ = w.WriteString("<" + element + ` class="` + class + `">` + open)
+		_, _ = w.WriteString(html.EscapeString(tex))
+		_, _ = w.WriteString(closing + "</" + element + ">")
+	}
+	if isBlock {
+		_ = w.WriteByte('\n')
+	}
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,77 @@
+package translator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/yuin/goldmark"
+)
+
+func TestMathDelimiters(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(math{config.MathClient}))
+	tests := []struct {
+		name     string
+		markdown string
+		html     string
+	}{
+		{"inline", "$x$", `<p><span class="math inline">\(x\)</span></p>` + "\n"},
+		{"inline display", "$$a$$ and $$b$$",
+			`<p><span class="math display">\[a\]</span> and <span class="math display">\[b\]</span></p>` + "\n"},
+		{"display at line start", "$$E$$ is the energy\n\nnext",
+			`<p><span class="math display">\[E\]</span> is the energy</p>` + "\n<p>next</p>\n"},
+		{"single line block", "text\n$$x$$\nmore",
+			"<p>text</p>\n" + `<div class="math display">\[x\]</div>` + "\n<p>more</p>\n"},
+		{"multi line block", "$$\nx\n$$\n\nnext", `<div class="math display">\[x\]</div>` + "\n<p>next</p>\n"},
+		{"unclosed block", "$$\nx", `<div class="math display">\[x\]</div>` + "\n"},
+		{"empty", "$$$$", "<p>$$$$</p>\n"},
+		{"prices", "costs $5 and $10", "<p>costs $5 and $10</p>\n"},
+		{"escaped", `\$x\$`, "<p>$x$</p>\n"},
+		{"space after opening", "$ x$", "<p>$ x$</p>\n"},
+		{"space before closing", "$x $", "<p>$x $</p>\n"},
+		{"lone display delimiter", "a $$ b", "<p>a $$ b</p>\n"},
+		{"markdown kept", `$a_1 * b_2\,c$`, `<p><span class="math inline">\(a_1 * b_2\,c\)</span></p>` + "\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := md.Convert([]byte(test.markdown), &b); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if b.String() != test.html {
+			t.Errorf("%s: %q => %q, want %q", test.name, test.markdown, b.String(), test.html)
+		}
+	}
+}
+
+func TestTexToMathML(t *testing.T) {
+	tests := []struct {
+		name   string
+		tex    string
+		mathML string
+	}{
+		{"identifier", "x", "<mi>x</mi>"},
+		{"unclosed brace", "{a", "<mrow><mi>a</mi></mrow>"},
+		{"unopened brace", "a}", "<mi>a</mi>"},
+		{"unclosed argument", `\frac{a`, "<mfrac><mrow><mi>a</mi></mrow><mrow></mrow></mfrac>"},
+		{"unclosed script", "x^{2", "<msup><mi>x</mi><mrow><mn>2</mn></mrow></msup>"},
+		{"fenced", `\left( x \right)`,
+			`<mrow><mo fence="true" stretchy="true">(</mo><mi>x</mi><mo fence="true" stretchy="true">)</mo></mrow>`},
+		{"left without right", `\left( x`, `<mrow><mo fence="true" stretchy="true">(</mo><mi>x</mi></mrow>`},
+		{"right without left", `x \right)`, "<mi>x</mi>"},
+	}
+	for _, test := range tests {
+		mathML := texToMathML(test.tex, false)
+		prefix := `<math xmlns="http://www.w3.org/1998/Math/MathML"><semantics><mrow>`
+		suffix := `</mrow><annotation encoding="application/x-tex">`
+		end := strings.Index(mathML, suffix)
+		if !strings.HasPrefix(mathML, prefix) || end < 0 || !strings.HasSuffix(mathML, "</annotation></semantics></math>") {
+			t.Errorf("%s: %q => malformed %q", test.name, test.tex, mathML)
+			continue
+		}
+		if body := mathML[len(prefix):end]; body != test.mathML {
+			t.Errorf("%s: %q => %q, want %q", test.name, test.tex, body, test.mathML)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package translator
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// texToMathML converts a tex formula to MathML. It covers what notes commonly use: scripts, fractions, roots,
+// greek letters, operators and relations, functions, accents, fonts, \left \right and matrix environments.
+// Unknown commands are rendered as errors in place, while the source is kept in an annotation.
+func texToMathML(tex string, display bool) string {
+	p := &texParser{tokens: tokenizeTex(tex), display: display}
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString(`><semantics><mrow>`)
+	b.WriteString(p.parseList(""))
+	b.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(tex))
+	b.WriteString(`</annotation></semantics></math>`)
+	return b.String()
+}
+
+type texToken struct {
+	command bool // \name, or \ followed by a symbol
+	value   string
+}
+
+func tokenizeTex(tex string) []texToken {
+	tokens := make([]texToken, 0)
+	runes := []rune(tex)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+		case r == '\\' && i+1 < len(runes):
+			j := i + 1
+			for j < len(runes) && isASCIILetter(runes[j]) {
+				j++
+			}
+			if j == i+1 {
+				j++
+			}
+			tokens = append(tokens, texToken{true, string(runes[i+1 : j])})
+			i = j - 1
+		case r >= '0' && r <= '9' || r == '.' && i+1 < len(runes) && runes[i+1] >= '0' && runes[i+1] <= '9':
+			j := i
+			for j < len(runes) && (runes[j] >= '0' && runes[j] <= '9' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, texToken{false, string(runes[i:j])})
+			i = j - 1
+		default:
+			tokens = append(tokens, texToken{false, string(r)})
+		}
+	}
+	return tokens
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+type texParser struct {
+	tokens  []texToken
+	pos     int
+	display bool
+}
+
+func (p *texParser) peek() (texToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return texToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *texParser) next() (texToken, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+// parseList parses elements until the end, a closing brace, \right, or \end. Inside environments, & and \\
+// separate cells and rows, which the caller handles, so they stop the list as well if env is not empty.
+func (p *texParser) parseList(env string) string {
+	var b strings.Builder
+	for {
+		t, ok := p.peek()
+		if !ok || !t.command && t.value == "}" || t.command && (t.value == "right" || t.value == "end") {
+			return b.String()
+		}
+		if env != "" && (!t.command && t.value == "&" || t.command && t.value == "\\") {
+			return b.String()
+		}
+		b.WriteString(p.parseScripted())
+	}
+}
+
+// parseScripted parses an element with its subscript and superscript if any
+func (p *texParser) parseScripted() string {
+	base, bigOperator := p.parseElement()
+	var sub, sup string
+	for {
+		t, ok := p.peek()
+		if !ok || t.command {
+			break
+		}
+		if t.value == "_" && sub == "" {
+			p.pos++
+			sub = p.parseArgument()
+		} else if t.value == "^" && sup == "" {
+			p.pos++
+			sup = p.parseArgument()
+		} else if t.value == "'" {
+			p.pos++
+			sup += "<mo>′</mo>"
+		} else {
+			break
+		}
+	}
+	if base == "" && (sub != "" || sup != "") {
+		base = "<mrow></mrow>"
+	}
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if bigOperator && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		return "<" + both + ">" + base + wrapRow(sub) + wrapRow(sup) + "</" + both + ">"
+	case sub != "":
+		return "<" + under + ">" + base + wrapRow(sub) + "</" + under + ">"
+	case sup != "":
+		return "<" + over + ">" + base + wrapRow(sup) + "</" + over + ">"
+	}
+	return base
+}
+
+// parseArgument parses a group in braces, or a single element
+func (p *texParser) parseArgument() string {
+	t, ok := p.peek()
+	if !ok {
+		return ""
+	}
+	if !t.command && t.value == "{" {
+		p.pos++
+		content := p.parseList("")
+		p.expect("}")
+		return content
+	}
+	element, _ := p.parseElement()
+	return element
+}
+
+// parseText parses a group in braces as plain text
+func (p *texParser) parseText() string {
+	t, ok := p.peek()
+	if !ok || t.command || t.value != "{" {
+		element, _ := p.parseElement()
+		return element
+	}
+	p.pos++
+	var b strings.Builder
+	depth := 0
+	for {
+		t, ok := p.next()
+		if !ok {
+			break
+		}
+		if !t.command && t.value == "{" {
+			depth++
+		} else if !t.command && t.value == "}" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		if t.command {
+			b.WriteString(" \\" + t.value)
+		} else {
+			b.WriteString(t.value)
+		}
+	}
+	return "<mtext>" + html.EscapeString(b.String()) + "</mtext>"
+}
+
+func (p *texParser) expect(value string) {
+	if t, ok := p.peek(); ok && !t.command && t.value == value {
+		p.pos++
+	}
+}
+
+// parseElement parses an element without scripts, telling if it is a big operator like \sum
+func (p *texParser) parseElement() (string, bool) {
+	t, ok := p.next()
+	if !ok {
+		return "", false
+	}
+	if !t.command {
+		switch {
+		case t.value == "{":
+			content := p.parseList("")
+			p.expect("}")
+			return wrapRow(content), false
+		case t.value[0] >= '0' && t.value[0] <= '9' || t.value[0] == '.' && len(t.value) > 1:
+			return "<mn>" + t.value + "</mn>", false
+		case t.value == "-":
+			return "<mo>−</mo>", false
+		case t.value == "~":
+			return `<mspace width="0.33em"></mspace>`, false
+		case strings.ContainsAny(t.value, "+=<>()[]|,;:!/*?@&"):
+			return "<mo>" + html.EscapeString(t.value) + "</mo>", false
+		default:
+			return "<mi>" + html.EscapeString(t.value) + "</mi>", false
+		}
+	}
+
+	name := t.value
+	if s, ok := texIdentifiers[name]; ok {
+		return "<mi>" + s + "</mi>", false
+	}
+	if s, ok := texBigOperators[name]; ok {
+		return "<mo>" + s + "</mo>", true
+	}
+	if s, ok := texOperators[name]; ok {
+		return "<mo>" + html.EscapeString(s) + "</mo>", false
+	}
+	if texFunctions[name] {
+		// limits of \lim, \max, etc. go under them in display mode
+		return "<mi>" + name + "</mi>", name == "lim" || name == "max" || name == "min" || name == "sup" || name == "inf"
+	}
+	if width, ok := texSpaces[name]; ok {
+		return `<mspace width="` + width + `"></mspace>`, false
+	}
+	if accent, ok := texAccents[name]; ok {
+		return "<mover accent=\"true\">" + wrapRow(p.parseArgument()) + "<mo>" + accent + "</mo></mover>", false
+	}
+	if variant, ok := texFonts[name]; ok {
+		return `<mstyle mathvariant="` + variant + `">` + p.parseArgument() + "</mstyle>", false
+	}
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		numerator := p.parseArgument()
+		return "<mfrac>" + wrapRow(numerator) + wrapRow(p.parseArgument()) + "</mfrac>", false
+	case "binom":
+		top := p.parseArgument()
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + wrapRow(top) + wrapRow(p.parseArgument()) + "</mfrac><mo>)</mo></mrow>", false
+	case "sqrt":
+		if t, ok := p.peek(); ok && !t.command && t.value == "[" {
+			p.pos++
+			var index strings.Builder
+			for {
+				t, ok := p.peek()
+				if !ok || !t.command && t.value == "]" {
+					break
+				}
+				index.WriteString(p.parseScripted())
+			}
+			p.expect("]")
+			return "<mroot>" + wrapRow(p.parseArgument()) + wrapRow(index.String()) + "</mroot>", false
+		}
+		return "<msqrt>" + p.parseArgument() + "</msqrt>", false
+	case "text", "textrm", "mbox", "operatorname":
+		return p.parseText(), false
+	case "underline":
+		return "<munder>" + wrapRow(p.parseArgument()) + "<mo>_</mo></munder>", false
+	case "left":
+		return p.parseFenced(), false
+	case "\\":
+		// line breaks are only meaningful in environments
+		return "", false
+	case "begin":
+		return p.parseEnvironment(), false
+	}
+	return "<merror><mtext>\\" + html.EscapeString(name) + "</mtext></merror>", false
+}
+
+// parseFenced parses \left( ... \right), \left is taken already
+func (p *texParser) parseFenced() string {
+	open := p.parseDelimiter()
+	content := p.parseList("")
+	closing := ""
+	if t, ok := p.peek(); ok && t.command && t.value == "right" {
+		p.pos++
+		closing = p.parseDelimiter()
+	}
+	return "<mrow>" + open + content + closing + "</mrow>"
+}
+
+func (p *texParser) parseDelimiter() string {
+	t, ok := p.next()
+	if !ok || !t.command && t.value == "." {
+		return ""
+	}
+	value := t.value
+	if t.command {
+		if s, ok := texOperators[value]; ok {
+			value = s
+		}
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(value) + "</mo>"
+}
+
+// parseEnvironment parses \begin{name} ... \end{name} of matrices, cases and aligned equations
+func (p *texParser) parseEnvironment() string {
+	name := p.parseName()
+	var rows []string
+	var cells []string
+	for {
+		cells = append(cells, "<mtd>"+p.parseList(name)+"</mtd>")
+		t, ok := p.next()
+		if !ok || t.command && t.value == "end" {
+			break
+		}
+		if t.command && t.value == "\\" {
+			rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+			cells = nil
+		} else if !t.command && t.value == "}" {
+			// unbalanced brace, stop here
+			break
+		}
+	}
+	rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+	p.parseName()
+
+	table := "<mtable>" + strings.Join(rows, "") + "</mtable>"
+	switch name {
+	case "pmatrix":
+		return "<mrow><mo>(</mo>" + table + "<mo>)</mo></mrow>"
+	case "bmatrix":
+		return "<mrow><mo>[</mo>" + table + "<mo>]</mo></mrow>"
+	case "vmatrix":
+		return "<mrow><mo>|</mo>" + table + "<mo>|</mo></mrow>"
+	case "cases":
+		return `<mrow><mo>{</mo><mtable columnalign="left left">` + strings.Join(rows, "") + "</mtable></mrow>"
+	case "aligned", "align", "align*":
+		return `<mtable columnalign="right left">` + strings.Join(rows, "") + "</mtable>"
+	}
+	return table
+}
+
+// parseName parses {name} after \begin and \end
+func (p *texParser) parseName() string {
+	t, ok := p.peek()
+	if !ok || t.command || t.value != "{" {
+		return ""
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		t, ok := p.next()
+		if !ok || !t.command && t.value == "}" {
+			break
+		}
+		b.WriteString(t.value)
+	}
+	return b.String()
+}
+
+// wrapRow groups elements as a single argument of MathML elements
+func wrapRow(content string) string {
+	return "<mrow>" + content + "</mrow>"
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ",
+	"Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅", "varnothing": "∅",
+	"aleph": "ℵ", "Re": "ℜ", "Im": "ℑ", "imath": "ı", "jmath": "ȷ",
+}
+
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	"bigcup": "⋃", "bigcap": "⋂", "bigvee": "⋁", "bigwedge": "⋀", "bigoplus": "⨁", "bigotimes": "⨂",
+}
+
+var texOperators = map[string]string{
+	"cdot": "⋅", "times": "×", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆", "circ": "∘",
+	"bullet": "∙", "oplus": "⊕", "otimes": "⊗", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨", "neg": "¬",
+	"lnot": "¬", "cup": "∪", "cap": "∩", "setminus": "∖",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈", "equiv": "≡",
+	"sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫", "prec": "≺", "succ": "≻",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"perp": "⊥", "parallel": "∥", "mid": "∣", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔", "Rightarrow": "⇒",
+	"Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦", "uparrow": "↑",
+	"downarrow": "↓", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "prime": "′", "angle": "∠",
+	"triangle": "△", "therefore": "∴", "because": "∵",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "vert": "|",
+	"Vert": "‖", "|": "‖", "{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "$": "$", "%": "%", "#": "#",
+	"&": "&", "_": "_",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true, "arcsin": true, "arccos": true,
+	"arctan": true, "sinh": true, "cosh": true, "tanh": true, "log": true, "ln": true, "lg": true, "exp": true,
+	"lim": true, "max": true, "min": true, "sup": true, "inf": true, "det": true, "dim": true, "ker": true,
+	"arg": true, "deg": true, "gcd": true, "mod": true, "Pr": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.17em", ":": "0.22em", ">": "0.22em", ";": "0.28em", " ": "0.33em", "quad": "1em", "qquad": "2em",
+	"!": "-0.17em",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "overrightarrow": "→", "dot": "˙",
+	"ddot": "¨", "tilde": "~", "widetilde": "~",
+}
+
+var texFonts = map[string]string{
+	"mathbf": "bold", "boldsymbol": "bold-italic", "mathit": "italic", "mathrm": "normal", "mathbb": "double-struck",
+	"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+}
@@ -0,0 +1,178 @@
+package translator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Metadata is read from the front matter of a note
+type Metadata struct {
+	Title       string
+	Date        *time.Time
+	Lastmod     *time.Time
+	PublishDate *time.Time // the note is not public until then
+	ExpiryDate  *time.Time // the note is not public since then
+	Description string
+	Tags        []string
+	Categories  []string
+	Author      string
+	Draft       bool
+	Weight      int
+	Layout      string                 // template file name relative to template root, overriding the one in config
+	TOCMinLevel int                    // levels of headings in table of contents, overriding the ones in config
+	TOCMaxLevel int                    // 0 if not set in "toc" field, e.g. toc: {min_level: 2, max_level: 3}
+	Params      map[string]interface{} // all other fields, together with fields in "params"
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// newMetadata picks known fields case-insensitively from the front matter, leaving the others in Params
+func newMetadata(header map[string]interface{}) *Metadata {
+	meta := new(Metadata)
+	meta.Params = make(map[string]interface{})
+	for key, value := range header {
+		value = normalizeValue(value)
+		switch strings.ToLower(key) {
+		case "title":
+			meta.Title = toString(value)
+		case "date":
+			meta.Date = toTime(value)
+		case "lastmod":
+			meta.Lastmod = toTime(value)
+		case "publishdate":
+			meta.PublishDate = toTime(value)
+		case "expirydate":
+			meta.ExpiryDate = toTime(value)
+		case "description":
+			meta.Description = toString(value)
+		case "tags":
+			meta.Tags = toStrings(value)
+		case "categories":
+			meta.Categories = toStrings(value)
+		case "author":
+			meta.Author = toString(value)
+		case "draft":
+			meta.Draft, _ = value.(bool)
+		case "weight":
+			meta.Weight = toInt(value)
+		case "layout":
+			meta.Layout = toString(value)
+		case "toc":
+			if toc, ok := value.(map[string]interface{}); ok {
+				for k, v := range toc {
+					switch strings.ToLower(k) {
+					case "min_level":
+						meta.TOCMinLevel = toInt(v)
+					case "max_level":
+						meta.TOCMaxLevel = toInt(v)
+					}
+				}
+			}
+		case "params":
+			if params, ok := value.(map[string]interface{}); ok {
+				for k, v := range params {
+					meta.Params[k] = v
+				}
+			}
+		default:
+			meta.Params[key] = value
+		}
+	}
+	return meta
+}
+
+// Terms returns values of a taxonomy in the front matter, e.g. "tags", or any other field holding a list of strings
+func (meta *Metadata) Terms(taxonomy string) []string {
+	switch strings.ToLower(taxonomy) {
+	case "tags":
+		return meta.Tags
+	case "categories":
+		return meta.Categories
+	}
+	for key, value := range meta.Params {
+		if strings.EqualFold(key, taxonomy) {
+			return toStrings(value)
+		}
+	}
+	return nil
+}
+
+// normalizeValue turns maps decoded from yaml into map[string]interface{}, as the ones from toml and json
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func toStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			result = append(result, toString(item))
+		}
+		return result
+	case string:
+		return []string{v}
+	default:
+		return nil
+	}
+}
+
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func toTime(value interface{}) *time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return &v
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return &t
+			}
+		}
+	}
+	return nil
+}
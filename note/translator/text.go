@@ -15,7 +15,7 @@ func newTextTranslator(path string) *textTranslator {
 	return t
 }
 
-func (t textTranslator) Translate() ([]byte, error) {
+func (t textTranslator) Translate() (*Document, error) {
 	content, err := os.ReadFile(t.path)
 	if err != nil {
 		return nil, err
@@ -23,7 +23,7 @@ func (t textTranslator) Translate() ([]byte, error) {
 
 	htmlContent := htmlTrans(content)
 
-	return htmlContent, nil
+	return newDocument(htmlContent, new(Metadata), string(content)), nil
 }
 
 func htmlTrans(content []byte) []byte {
@@ -1,18 +1,114 @@
 package translator
 
-import "path/filepath"
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Streamlet/NoteIsSite/config"
+)
 
 type Translator interface {
-	Translate() ([]byte, error)
+	Translate() (*Document, error)
+	// ReadMetadata reads metadata only, without translating the content
+	ReadMetadata() (*Metadata, error)
+}
+
+// Factory creates a translator for the file
+type Factory func(path string) Translator
+
+// names of built-in translators
+const (
+	Markdown = "markdown"
+	Text     = "text"
+	HTML     = "html" // output as-is
+)
+
+var (
+	registryLock sync.RWMutex
+	factories    = map[string]Factory{
+		Markdown: func(path string) Translator { return newMarkdownTranslator(path) },
+		Text:     func(path string) Translator { return newTextTranslator(path) },
+		HTML:     func(path string) Translator { return newDefaultTranslator(path) },
+	}
+	extensions = map[string]string{
+		".md":  Markdown,
+		".txt": Text,
+	}
+	mimeTypes = map[string]string{
+		"text/markdown":   Markdown,
+		"text/x-markdown": Markdown,
+		"text/plain":      Text,
+		"text/html":       HTML,
+	}
+)
+
+// Register adds or replaces a translator by name
+func Register(name string, factory Factory) {
+	defer registryLock.Unlock()
+	registryLock.Lock()
+
+	factories[name] = factory
+}
+
+// RegisterExtension maps a file extension, e.g. ".md", to a translator name
+func RegisterExtension(ext string, name string) {
+	defer registryLock.Unlock()
+	registryLock.Lock()
+
+	extensions[strings.ToLower(ext)] = name
+}
+
+// RegisterMimeType maps a MIME type, e.g. "text/markdown", to a translator name
+func RegisterMimeType(mimeType string, name string) {
+	defer registryLock.Unlock()
+	registryLock.Lock()
+
+	mimeTypes[strings.ToLower(mimeType)] = name
 }
 
+// LoadConfig registers translators in site config
+func LoadConfig() error {
+	c := config.GetSiteConfig().Translator
+	for _, e := range c.External {
+		if e.Name == "" || len(e.Command) == 0 {
+			return fmt.Errorf("translator.external MUST have name and command")
+		}
+		external := e
+		Register(external.Name, func(path string) Translator { return newExternalTranslator(path, &external) })
+		for _, ext := range external.Extensions {
+			RegisterExtension(ext, external.Name)
+		}
+	}
+	for ext, name := range c.Extensions {
+		registryLock.RLock()
+		_, ok := factories[name]
+		registryLock.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown translator %s for %s", name, ext)
+		}
+		RegisterExtension(ext, name)
+	}
+	return nil
+}
+
+// New looks up the translator by extension of the file, and then by its MIME type. Content of files unknown to any
+// translator are output as-is.
 func New(path string) Translator {
-	switch filepath.Ext(path) {
-	case ".md":
-		return newMarkdownTranslator(path)
-	case ".txt":
-		return newTextTranslator(path)
-	default:
-		return newDefaultTranslator(path)
+	defer registryLock.RUnlock()
+	registryLock.RLock()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	name, ok := extensions[ext]
+	if !ok {
+		if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+			name, ok = mimeTypes[mediaType]
+		}
+	}
+	if factory, ok := factories[name]; ok {
+		return factory(path)
 	}
+	return newDefaultTranslator(path)
 }
@@ -0,0 +1,98 @@
+package translator
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// wikiLinks is a goldmark extension for [[Note Name]] and [[path/to/file.md|label]], optionally with #anchor
+type wikiLinks struct{}
+
+func (e wikiLinks) Extend(m goldmark.Markdown) {
+	// before the link parser, which would take [[ as the beginning of a link
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(wikiLinkParser{}, 199)))
+}
+
+type wikiLinkParser struct{}
+
+func (p wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+	end := wikiLinkEnd(line)
+	if end < 0 {
+		return nil
+	}
+	inner := string(line[2:end])
+	if strings.TrimSpace(inner) == "" {
+		return nil
+	}
+	block.Advance(end + 2)
+
+	target, label := inner, inner
+	if i := strings.Index(inner, "|"); i >= 0 {
+		target, label = inner[:i], inner[i+1:]
+	}
+	target, label = strings.TrimSpace(target), strings.TrimSpace(label)
+	anchor := ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, anchor = target[:i], target[i:]
+	}
+	if label == "" {
+		label = target
+	}
+
+	link := ast.NewLink()
+	link.AppendChild(link, ast.NewString([]byte(label)))
+	if target == "" {
+		link.Destination = []byte(anchor)
+		return link
+	}
+	resolver, _ := pc.Get(linkResolverKey).(LinkResolver)
+	source, _ := pc.Get(sourcePathKey).(string)
+	if resolver != nil {
+		if uri, ok := resolver.ResolveWikiLink(source, target); ok {
+			link.Destination = []byte(uri + anchor)
+			addLinkRef(pc, LinkRef{Wiki: true, Target: target, Link: string(link.Destination)})
+			return link
+		}
+	}
+	link.Destination = []byte(target + anchor)
+	link.SetAttributeString("class", []byte(brokenLinkClass))
+	addLinkRef(pc, LinkRef{Wiki: true, Target: target, Link: string(link.Destination)})
+	return link
+}
+
+// wikiLinkEnd returns position of the closing ]] of the wiki link at the beginning of line, or -1 if not closed.
+// Brackets inside are allowed in pairs, as in file names like [1]overview.md.
+func wikiLinkEnd(line []byte) int {
+	depth := 0
+	for i := 2; i < len(line); i++ {
+		switch line[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			} else if i+1 < len(line) && line[i+1] == ']' {
+				return i
+			} else {
+				return -1
+			}
+		case '\n':
+			return -1
+		}
+	}
+	return -1
+}
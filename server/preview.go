@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Streamlet/NoteIsSite/config"
+)
+
+const (
+	previewQuery  = "preview"
+	previewCookie = "nis_preview"
+)
+
+// PreviewToken makes a token showing unpublished notes until expiry, signed by the secret in site config.
+// A token is the expiry in unix time and its signature, separated by a dot.
+func PreviewToken(expiry time.Time) (string, error) {
+	secret := config.GetSiteConfig().Preview.Secret
+	if secret == "" {
+		return "", fmt.Errorf("preview.secret MUST be set")
+	}
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	return expires + "." + sign(secret, expires), nil
+}
+
+func sign(secret string, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPreviewToken returns expiry of the token, or false if it is forged or expired
+func verifyPreviewToken(token string) (time.Time, bool) {
+	secret := config.GetSiteConfig().Preview.Secret
+	parts := strings.SplitN(token, ".", 2)
+	if secret == "" || len(parts) != 2 {
+		return time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[1]), []byte(sign(secret, parts[0]))) {
+		return time.Time{}, false
+	}
+	expiry := time.Unix(expires, 0)
+	if !time.Now().Before(expiry) {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// isSecure reports whether the site is served over https, by the server itself, or by a reverse proxy in front of it
+// as base_url tells
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(strings.ToLower(config.GetSiteConfig().Site.BaseUrl), "https://")
+}
+
+// isPreview reports whether unpublished notes are shown for the request. A valid token in query is kept in cookie,
+// so that pages linked from the previewed one are previewed as well.
+func isPreview(w http.ResponseWriter, r *http.Request) bool {
+	if config.GetSiteConfig().Preview.Enabled {
+		return true
+	}
+	if token := r.URL.Query().Get(previewQuery); token != "" {
+		if expiry, ok := verifyPreviewToken(token); ok {
+			http.SetCookie(w, &http.Cookie{Name: previewCookie, Value: token, Path: "/", Expires: expiry, HttpOnly: true,
+				Secure: isSecure(r), SameSite: http.SameSiteLaxMode})
+			return true
+		}
+	}
+	if cookie, err := r.Cookie(previewCookie); err == nil {
+		if _, ok := verifyPreviewToken(cookie.Value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -8,40 +8,75 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/Streamlet/NoteIsSite/config"
 	"github.com/Streamlet/NoteIsSite/note"
 )
 
-func newRouter(noteRoot string, templateRoot string) (http.Handler, error) {
+func newRouter(noteRoot string, templateRoot string) (http.Handler, note.Router, error) {
 	notesRouter, err := note.NewRouter(noteRoot, templateRoot)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	// search and status are served only if no note or category has their uris, conflicts are reported by notesRouter
+	endpoints := make(map[string]http.HandlerFunc)
+	if config.GetSiteConfig().Search.Enabled {
+		endpoints[note.SearchUri] = func(w http.ResponseWriter, r *http.Request) {
+			content, mimeType, err := notesRouter.Search(r.URL.Query().Get("q"), false)
+			respond(w, r, content, mimeType, err)
+		}
+		endpoints[note.SearchJSONUri] = func(w http.ResponseWriter, r *http.Request) {
+			content, mimeType, err := notesRouter.Search(r.URL.Query().Get("q"), true)
+			respond(w, r, content, mimeType, err)
+		}
+	}
+	if config.GetSiteConfig().Server.Status {
+		endpoints[note.StatusUri] = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			content, mimeType, err := notesRouter.Status()
+			respond(w, r, content, mimeType, err)
+		}
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		content, mimeType, err := notesRouter.Route(r.URL.Path)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Println(r.RequestURI, "404:", err.Error())
-				w.WriteHeader(http.StatusNotFound)
-			} else {
-				log.Println(r.RequestURI, "500:", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-			}
+		preview := isPreview(w, r)
+		if preview {
+			// unpublished notes must not be kept by any cache on the way
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		content, mimeType, err := notesRouter.Route(r.URL.Path, preview)
+		if endpoint, ok := endpoints[r.URL.Path]; ok && os.IsNotExist(err) {
+			endpoint(w, r)
+			return
+		}
+		respond(w, r, content, mimeType, err)
+	})
+
+	return mux, notesRouter, nil
+}
+
+func respond(w http.ResponseWriter, r *http.Request, content []byte, mimeType string, err error) {
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Println(r.RequestURI, "404:", err.Error())
+			w.WriteHeader(http.StatusNotFound)
 		} else {
-			if mimeType == "" {
-				if urlParts, err := url.Parse(r.RequestURI); err == nil {
-					ext := filepath.Ext(urlParts.Path)
-					if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-						w.Header().Add("Content-Type", mimeType)
-					} else {
-						w.Header().Add("Content-Type", "application/octet-stream")
-					}
+			log.Println(r.RequestURI, "500:", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	} else {
+		if mimeType != "" {
+			w.Header().Add("Content-Type", mimeType)
+		} else {
+			if urlParts, err := url.Parse(r.RequestURI); err == nil {
+				ext := filepath.Ext(urlParts.Path)
+				if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+					w.Header().Add("Content-Type", mimeType)
+				} else {
+					w.Header().Add("Content-Type", "application/octet-stream")
 				}
 			}
-			w.WriteHeader(http.StatusOK)
 		}
-		_, _ = w.Write(content)
-	})
-
-	return mux, nil
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write(content)
 }
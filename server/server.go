@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"github.com/Streamlet/NoteIsSite/global"
+	"github.com/Streamlet/NoteIsSite/note"
 )
 
 type HttpServer interface {
@@ -18,7 +19,7 @@ type HttpServer interface {
 func NewPortServer(port uint, noteRoot string, templateRoot string) (HttpServer, error) {
 	var err error
 	server := new(portServer)
-	server.Handler, err = newRouter(noteRoot, templateRoot)
+	server.Handler, server.notesRouter, err = newRouter(noteRoot, templateRoot)
 	server.port = port
 	return server, err
 }
@@ -26,19 +27,21 @@ func NewPortServer(port uint, noteRoot string, templateRoot string) (HttpServer,
 func NewSockServer(sock string, noteRoot string, templateRoot string) (HttpServer, error) {
 	var err error
 	server := new(sockServer)
-	server.Handler, err = newRouter(noteRoot, templateRoot)
+	server.Handler, server.notesRouter, err = newRouter(noteRoot, templateRoot)
 	server.sock = sock
 	return server, err
 }
 
 type portServer struct {
 	http.Server
-	port uint
+	notesRouter note.Router
+	port        uint
 }
 
 type sockServer struct {
 	http.Server
-	sock string
+	notesRouter note.Router
+	sock        string
 }
 
 func serve(s *http.Server, l net.Listener) {
@@ -50,11 +53,13 @@ func serve(s *http.Server, l net.Listener) {
 	}()
 }
 
-func shutdown(s *http.Server) error {
-	return s.Shutdown(context.Background())
+func shutdown(s *http.Server, r note.Router) error {
+	err := s.Shutdown(context.Background())
+	_ = r.Close()
+	return err
 }
 
-func (s portServer) Serve() error {
+func (s *portServer) Serve() error {
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
 	if err != nil {
 		return err
@@ -63,11 +68,11 @@ func (s portServer) Serve() error {
 	return nil
 }
 
-func (s portServer) Shutdown() error {
-	return shutdown(&s.Server)
+func (s *portServer) Shutdown() error {
+	return shutdown(&s.Server, s.notesRouter)
 }
 
-func (s sockServer) Serve() error {
+func (s *sockServer) Serve() error {
 	_ = os.Remove(s.sock)
 	l, err := net.Listen("unix", s.sock)
 	if err != nil {
@@ -77,8 +82,8 @@ func (s sockServer) Serve() error {
 	return nil
 }
 
-func (s sockServer) Shutdown() error {
-	err := shutdown(&s.Server)
+func (s *sockServer) Shutdown() error {
+	err := shutdown(&s.Server, s.notesRouter)
 	_ = os.Remove(s.sock)
 	return err
 }
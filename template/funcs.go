@@ -0,0 +1,78 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Streamlet/NoteIsSite/note/translator"
+)
+
+// funcMap is available in all templates
+var funcMap = map[string]interface{}{
+	"dateFormat":  dateFormat,
+	"truncate":    truncate,
+	"urlJoin":     urlJoin,
+	"safeHTML":    safeHTML,
+	"markdownify": markdownify,
+}
+
+// dateFormat formats a time.Time, *time.Time or RFC 3339 string with a golang time layout, e.g. "2006-01-02"
+func dateFormat(layout string, t interface{}) (string, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(layout), nil
+	default:
+		return "", fmt.Errorf("dateFormat: unsupported type %T", t)
+	}
+}
+
+// truncate cuts s to at most length characters, appending "…" if anything was cut
+func truncate(length int, s string) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:length]) + "…"
+}
+
+// urlJoin joins parts of url with exactly one slash between them
+func urlJoin(parts ...string) string {
+	result := ""
+	for i, p := range parts {
+		if i > 0 {
+			p = strings.TrimPrefix(p, "/")
+			if !strings.HasSuffix(result, "/") && p != "" {
+				result += "/"
+			}
+		}
+		result += p
+	}
+	return result
+}
+
+// safeHTML marks s as trusted HTML, which is output as-is
+func safeHTML(s string) HTML {
+	return HTML(s)
+}
+
+// markdownify converts markdown text to HTML
+func markdownify(s string) (HTML, error) {
+	content, err := translator.Markdownify([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return HTML(content), nil
+}
@@ -2,12 +2,19 @@ package template
 
 import (
 	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
-	"text/template"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/Streamlet/NoteIsSite/config"
+	"github.com/Streamlet/NoteIsSite/note/translator"
 )
 
 type Globals struct {
@@ -17,18 +24,188 @@ func (Globals) CurrentYear() string {
 	return time.Now().Format("2006")
 }
 
+// HighlightStylesheet returns uri of the stylesheet of highlighted code blocks, or empty string if they have inline
+// styles
+func (Globals) HighlightStylesheet() string {
+	if !config.GetSiteConfig().Markdown.HighlightClasses {
+		return ""
+	}
+	return translator.HighlightCSSUri
+}
+
+// FeedsEnabled reports whether atom feeds are generated as feed.xml at the root and under each category
+func (Globals) FeedsEnabled() bool {
+	return config.GetSiteConfig().Feed.Enabled
+}
+
+// SearchEnabled reports whether the site has search pages, which static sites don't
+func (Globals) SearchEnabled() bool {
+	return config.GetSiteConfig().Search.Enabled
+}
+
 type BasicItem struct {
 	Uri        string
 	Name       string
+	Meta       *translator.Metadata // front matter of notes, or categories with index, nil for others
+	Taxonomies map[string][]*Term   // terms of each taxonomy in site config, e.g. .Taxonomies.tags, notes only
 	IsAncestor bool
 	Children   []*BasicItem
 	Parent     *BasicItem
 }
 
+// Term is a value of a taxonomy, e.g. a tag
+type Term struct {
+	Name string
+	Uri  string
+}
+
+// HTML is trusted HTML, which is output as-is in templates
+type HTML = htmltemplate.HTML
+
 type PageData struct {
 	Globals
 	*BasicItem
-	Content string
+	Content   HTML
+	Document  *translator.Document // nil for categories without index
+	Backlinks []*BasicItem         // notes linking to this note
+}
+
+// SetDocument fills the page with a translated note
+func (data *PageData) SetDocument(doc *translator.Document) {
+	data.Document = doc
+	data.Content = HTML(doc.HTML)
+	data.Meta = doc.Meta
+}
+
+// TOCItem is a heading in table of contents, with headings of lower levels after it nested in
+type TOCItem struct {
+	*translator.Heading
+	Children []*TOCItem
+}
+
+// TOC returns headings between min and max levels in site config, or in front matter if set, nested by levels
+func (data PageData) TOC() []*TOCItem {
+	if data.Document == nil {
+		return nil
+	}
+	minLevel, maxLevel := int(config.GetSiteConfig().TOC.MinLevel), int(config.GetSiteConfig().TOC.MaxLevel)
+	if meta := data.Document.Meta; meta != nil {
+		if meta.TOCMinLevel > 0 {
+			minLevel = meta.TOCMinLevel
+		}
+		if meta.TOCMaxLevel > 0 {
+			maxLevel = meta.TOCMaxLevel
+		}
+	}
+
+	toc := make([]*TOCItem, 0)
+	var ancestors []*TOCItem
+	for _, heading := range data.Document.TOC {
+		if heading.Level < minLevel || heading.Level > maxLevel {
+			continue
+		}
+		item := &TOCItem{Heading: heading}
+		for len(ancestors) > 0 && ancestors[len(ancestors)-1].Level >= heading.Level {
+			ancestors = ancestors[:len(ancestors)-1]
+		}
+		if len(ancestors) == 0 {
+			toc = append(toc, item)
+		} else {
+			parent := ancestors[len(ancestors)-1]
+			parent.Children = append(parent.Children, item)
+		}
+		ancestors = append(ancestors, item)
+	}
+	return toc
+}
+
+func (data PageData) Summary() string {
+	if data.Document == nil {
+		return ""
+	}
+	return data.Document.Summary
+}
+
+func (data PageData) WordCount() int {
+	if data.Document == nil {
+		return 0
+	}
+	return data.Document.WordCount
+}
+
+// SearchPageData is the data of search template, in which BasicItem is the root
+type SearchPageData struct {
+	PageData
+	Query   string
+	Results []*SearchResult
+}
+
+type SearchResult struct {
+	Uri     string
+	Name    string
+	Meta    *translator.Metadata
+	Snippet string // plain text around the first match
+	Score   float64
+}
+
+// TaxonomyPageData is the data of taxonomy template, in which BasicItem is the root
+type TaxonomyPageData struct {
+	PageData
+	Taxonomy string          // e.g. "tags"
+	Term     *TaxonomyTerm   // nil for the page listing all terms
+	Terms    []*TaxonomyTerm // all terms of the taxonomy, sorted by name
+}
+
+type TaxonomyTerm struct {
+	Term
+	Items []*BasicItem // notes with the term, in order of the tree
+}
+
+// ArchivePageData is the data of archive template, in which BasicItem is the root
+type ArchivePageData struct {
+	PageData
+	Year  int            // 0 for the page of all years
+	Month int            // 0 for the page of all years or a whole year
+	Items []*ArchiveItem // notes of the page, the latest first
+	Years []*ArchiveYear // all years with notes, the latest first
+}
+
+type ArchiveItem struct {
+	*BasicItem
+	Date time.Time // in front matter, or modification time of the file
+}
+
+type ArchiveYear struct {
+	Year   int
+	Uri    string
+	Count  int
+	Months []*ArchiveMonth // months with notes, the latest first
+}
+
+type ArchiveMonth struct {
+	Month int
+	Uri   string
+	Count int
+}
+
+// Title returns title in front matter if exists, otherwise the name
+func (result SearchResult) Title() string {
+	if result.Meta != nil && result.Meta.Title != "" {
+		return result.Meta.Title
+	}
+	return result.Name
+}
+
+// Title returns title in front matter if exists, otherwise the name
+func (item BasicItem) Title() string {
+	if item.Meta != nil && item.Meta.Title != "" {
+		return item.Meta.Title
+	}
+	return item.Name
+}
+
+func (item BasicItem) Tags() []*Term {
+	return item.Taxonomies["tags"]
 }
 
 func (item BasicItem) HasChildren() bool {
@@ -77,10 +254,20 @@ func (item BasicItem) Ancestors() []*BasicItem {
 
 type Executor interface {
 	Update(templateRoot string) error
+	// Version increases on every successful Update
+	Version() uint64
 
 	GetIndex(data PageData) ([]byte, error)
-	GetCategory(data PageData) ([]byte, error)
-	GetContent(data PageData) ([]byte, error)
+	// templateName is a file name relative to template root, overriding the category template in site config if not empty
+	GetCategory(data PageData, templateName string) ([]byte, error)
+	// templateName is a file name relative to template root, overriding the content template in site config if not empty
+	GetContent(data PageData, templateName string) ([]byte, error)
+	// returns os.ErrNotExist if there is no search template in site config
+	GetSearch(data SearchPageData) ([]byte, error)
+	// returns os.ErrNotExist if there is no taxonomy template in site config
+	GetTaxonomy(data TaxonomyPageData) ([]byte, error)
+	// returns os.ErrNotExist if there is no archive template in site config
+	GetArchive(data ArchivePageData) ([]byte, error)
 
 	Get404() []byte
 	Get500() []byte
@@ -97,42 +284,178 @@ func NewExecutor(templateRoot string) (Executor, error) {
 
 type templateData struct {
 	lock             sync.RWMutex
-	indexTemplate    string
-	categoryTemplate string
-	contentTemplate  string
+	indexTemplate    executable
+	categoryTemplate executable
+	contentTemplate  executable
+	searchTemplate   executable // nil if not configured
+	taxonomyTemplate executable // nil if not configured
+	archiveTemplate  executable // nil if not configured
 	err404           []byte
 	err500           []byte
+	version          uint64
+
+	templateRoot string
+	partials     []partial
+	overrides    map[string]executable // templates named by categories or notes, parsed on first use
 }
 
+// Update parses all templates in templateRoot. If any of them is broken, the previous templates are kept.
 func (td *templateData) Update(templateRoot string) error {
 	c := config.GetSiteConfig().Template
-	index, err := os.ReadFile(templateRoot + "/" + c.IndexTemplate)
+	partials, err := loadPartials(filepath.Join(templateRoot, c.PartialsDir))
+	if err != nil {
+		return err
+	}
+	index, err := parseTemplate(templateRoot, c.IndexTemplate, partials)
 	if err != nil {
 		return err
 	}
-	category, err := os.ReadFile(templateRoot + "/" + c.CategoryTemplate)
+	category, err := parseTemplate(templateRoot, c.CategoryTemplate, partials)
 	if err != nil {
 		return err
 	}
-	content, err := os.ReadFile(templateRoot + "/" + c.ContentTemplate)
+	content, err := parseTemplate(templateRoot, c.ContentTemplate, partials)
 	if err != nil {
 		return err
 	}
+	var search executable
+	if c.SearchTemplate != "" {
+		if search, err = parseTemplate(templateRoot, c.SearchTemplate, partials); err != nil {
+			return err
+		}
+	}
+	var taxonomy executable
+	if c.TaxonomyTemplate != "" {
+		if taxonomy, err = parseTemplate(templateRoot, c.TaxonomyTemplate, partials); err != nil {
+			return err
+		}
+	}
+	var archive executable
+	if c.ArchiveTemplate != "" {
+		if archive, err = parseTemplate(templateRoot, c.ArchiveTemplate, partials); err != nil {
+			return err
+		}
+	}
 	err404, _ := os.ReadFile(templateRoot + "/" + c.ErrorPage404)
 	err500, _ := os.ReadFile(templateRoot + "/" + c.ErrorPage500)
 
 	defer td.lock.Unlock()
 	td.lock.Lock()
 
-	td.indexTemplate = string(index)
-	td.categoryTemplate = string(category)
-	td.contentTemplate = string(content)
+	td.indexTemplate = index
+	td.categoryTemplate = category
+	td.contentTemplate = content
+	td.searchTemplate = search
+	td.taxonomyTemplate = taxonomy
+	td.archiveTemplate = archive
 	td.err404 = err404
 	td.err500 = err500
+	td.version++
+	td.templateRoot = templateRoot
+	td.partials = partials
+	// overrides are parsed again on next use, as some of them may have been renamed or removed
+	td.overrides = make(map[string]executable)
 
 	return nil
 }
 
+// override returns the template named by a category or a note, parsing it on first use
+func (td *templateData) override(name string) (executable, error) {
+	td.lock.RLock()
+	t, ok := td.overrides[name]
+	templateRoot, partials, version := td.templateRoot, td.partials, td.version
+	td.lock.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	if cleaned := filepath.Clean(name); filepath.IsAbs(cleaned) ||
+		cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("template %s is out of template root", name)
+	}
+	t, err := parseTemplate(templateRoot, name, partials)
+	if err != nil {
+		return nil, err
+	}
+
+	defer td.lock.Unlock()
+	td.lock.Lock()
+
+	// templates may have been updated during parsing, leave it to the next use
+	if td.version == version {
+		td.overrides[name] = t
+	}
+	return t, nil
+}
+
+// executable is a parsed html/template or text/template
+type executable interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+type partial struct {
+	name    string
+	content string
+}
+
+// loadPartials reads all files in dir recursively. A partial is named by its path relative to dir without extension,
+// e.g. "nav" for nav.html, "blog/header" for blog/header.html.
+func loadPartials(dir string) ([]partial, error) {
+	partials := make([]partial, 0)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return partials, nil
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name)))
+		partials = append(partials, partial{name, string(content)})
+		return nil
+	})
+	return partials, err
+}
+
+// parseTemplate parses the template file together with all partials. Partials are parsed first, so that the
+// template file can override blocks defined in them.
+func parseTemplate(templateRoot string, name string, partials []partial) (executable, error) {
+	content, err := os.ReadFile(templateRoot + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if config.GetSiteConfig().Template.LegacyTextTemplate {
+		t := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcMap))
+		for _, p := range partials {
+			if _, err := t.New(p.name).Parse(p.content); err != nil {
+				return nil, err
+			}
+		}
+		return t.Parse(string(content))
+	}
+	t := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcMap))
+	for _, p := range partials {
+		if _, err := t.New(p.name).Parse(p.content); err != nil {
+			return nil, err
+		}
+	}
+	return t.Parse(string(content))
+}
+
+func (td *templateData) Version() uint64 {
+	defer td.lock.RUnlock()
+	td.lock.RLock()
+
+	return td.version
+}
+
 func (td *templateData) GetIndex(data PageData) ([]byte, error) {
 	defer td.lock.RUnlock()
 	td.lock.RLock()
@@ -140,30 +463,74 @@ func (td *templateData) GetIndex(data PageData) ([]byte, error) {
 	return td.execute(td.indexTemplate, data)
 }
 
-func (td *templateData) GetCategory(data PageData) ([]byte, error) {
+func (td *templateData) GetCategory(data PageData, templateName string) ([]byte, error) {
+	if templateName != "" {
+		return td.executeOverride(templateName, data)
+	}
+
 	defer td.lock.RUnlock()
 	td.lock.RLock()
 
 	return td.execute(td.categoryTemplate, data)
 }
 
-func (td *templateData) GetContent(data PageData) ([]byte, error) {
+func (td *templateData) GetContent(data PageData, templateName string) ([]byte, error) {
+	if templateName != "" {
+		return td.executeOverride(templateName, data)
+	}
+
 	defer td.lock.RUnlock()
 	td.lock.RLock()
 
 	return td.execute(td.contentTemplate, data)
 }
 
-func (td *templateData) execute(tmpl string, data interface{}) ([]byte, error) {
-	tt := template.New("")
-	_, err := tt.Parse(tmpl)
+func (td *templateData) GetSearch(data SearchPageData) ([]byte, error) {
+	defer td.lock.RUnlock()
+	td.lock.RLock()
+
+	if td.searchTemplate == nil {
+		return td.err404, os.ErrNotExist
+	}
+	return td.execute(td.searchTemplate, data)
+}
+
+func (td *templateData) GetTaxonomy(data TaxonomyPageData) ([]byte, error) {
+	defer td.lock.RUnlock()
+	td.lock.RLock()
+
+	if td.taxonomyTemplate == nil {
+		return td.err404, os.ErrNotExist
+	}
+	return td.execute(td.taxonomyTemplate, data)
+}
+
+func (td *templateData) GetArchive(data ArchivePageData) ([]byte, error) {
+	defer td.lock.RUnlock()
+	td.lock.RLock()
+
+	if td.archiveTemplate == nil {
+		return td.err404, os.ErrNotExist
+	}
+	return td.execute(td.archiveTemplate, data)
+}
+
+func (td *templateData) executeOverride(templateName string, data interface{}) ([]byte, error) {
+	tt, err := td.override(templateName)
 	if err != nil {
-		return td.err500, err
+		return td.Get500(), err
 	}
 
+	defer td.lock.RUnlock()
+	td.lock.RLock()
+
+	return td.execute(tt, data)
+}
+
+func (td *templateData) execute(tt executable, data interface{}) ([]byte, error) {
 	var buffer []byte
 	w := bytes.NewBuffer(buffer)
-	err = tt.Execute(w, data)
+	err := tt.Execute(w, data)
 	if err != nil {
 		return td.err500, err
 	}